@@ -0,0 +1,471 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"llmsh/pkg/cache"
+	"llmsh/pkg/config"
+
+	"github.com/spf13/cobra"
+)
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the prediction cache",
+	Long:  `Inspect and manage the SQLite cache of predicted commands.`,
+}
+
+var (
+	cacheMethod string
+	cacheCWD    string
+	cacheMatch  string
+	cacheLimit  int
+	cacheTop    int
+)
+
+var cacheStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show cache statistics",
+	Long: `Show the number of cached entries, how often lookups hit the cache, the most
+served commands and how old the entries are.`,
+	Args: cobra.NoArgs,
+	RunE: runCacheStats,
+}
+
+var cacheListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List cache entries",
+	Long: `List cache entries, most recently used first. Keys are shortened; any unique
+prefix of a key works with show and delete.`,
+	Args: cobra.NoArgs,
+	RunE: runCacheList,
+}
+
+var cacheShowCmd = &cobra.Command{
+	Use:   "show KEY",
+	Short: "Show a cache entry",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runCacheShow,
+}
+
+var cacheDeleteCmd = &cobra.Command{
+	Use:   "delete [KEY...]",
+	Short: "Delete cache entries",
+	Long: `Delete the entries with the given keys, or those matching --method, --cwd
+and --match. Use 'llmsh clean' to drop the whole cache.`,
+	RunE: runCacheDelete,
+}
+
+var cacheExportCmd = &cobra.Command{
+	Use:   "export [file]",
+	Short: "Export cache entries as JSON lines",
+	Long:  `Write every cache entry as a line of JSON to the file, or to stdout.`,
+	Args:  cobra.MaximumNArgs(1),
+	RunE:  runCacheExport,
+}
+
+var cacheImportCmd = &cobra.Command{
+	Use:   "import [file]",
+	Short: "Import cache entries from JSON lines",
+	Long: `Read cache entries written by 'llmsh cache export' from the file, or from
+stdin. Imported entries replace those with the same key.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runCacheImport,
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Evict expired and excess cache entries",
+	Long: `Remove entries older than cache.ttl_days and all but the cache.max_entries
+most recently used ones, then reclaim the space they used. Writes to the cache
+already do this once a day; prune runs it right away.`,
+	RunE: runCachePrune,
+}
+
+func init() {
+	cacheStatsCmd.Flags().IntVar(&cacheTop, "top", 10, "Number of top commands to show")
+
+	cacheListCmd.Flags().StringVar(&cacheMethod, "method", "", "Only entries of this method (predict, complete, nl2cmd)")
+	cacheListCmd.Flags().StringVar(&cacheCWD, "cwd", "", "Only entries predicted in this directory or below it")
+	cacheListCmd.Flags().StringVar(&cacheMatch, "match", "", "Only entries whose command matches this glob")
+	cacheListCmd.Flags().IntVarP(&cacheLimit, "limit", "n", 50, "Maximum number of entries to list, 0 for all")
+
+	cacheDeleteCmd.Flags().StringVar(&cacheMethod, "method", "", "Delete entries of this method (predict, complete, nl2cmd)")
+	cacheDeleteCmd.Flags().StringVar(&cacheCWD, "cwd", "", "Delete entries predicted in this directory or below it")
+	cacheDeleteCmd.Flags().StringVar(&cacheMatch, "match", "", "Delete entries whose command matches this glob")
+
+	cacheCmd.AddCommand(cacheStatsCmd)
+	cacheCmd.AddCommand(cacheListCmd)
+	cacheCmd.AddCommand(cacheShowCmd)
+	cacheCmd.AddCommand(cacheDeleteCmd)
+	cacheCmd.AddCommand(cacheExportCmd)
+	cacheCmd.AddCommand(cacheImportCmd)
+	cacheCmd.AddCommand(cachePruneCmd)
+}
+
+// openCache opens the cache database with the expiry and eviction settings
+// from the configuration
+func openCache(cfg *config.Config) (*cache.Cache, error) {
+	methodTTLs := make(map[string]time.Duration)
+	keys := make(map[string]cache.KeyOptions)
+	for _, method := range config.CachedMethods {
+		methodTTLs[method] = cfg.Cache.MethodTTL(method)
+		keys[method] = cacheKeyOptions(cfg, method)
+	}
+
+	return cache.Open(cfg.Cache.DBPath, cache.Options{
+		TTL:        time.Duration(cfg.Cache.TTLDays) * 24 * time.Hour,
+		MethodTTLs: methodTTLs,
+		MaxEntries: cfg.Cache.MaxEntries,
+		Keys:       keys,
+	})
+}
+
+// cacheKeyOptions returns the configured composition of a method's cache keys
+func cacheKeyOptions(cfg *config.Config, method string) cache.KeyOptions {
+	key := cfg.Cache.MethodKey(method)
+	return cache.KeyOptions{
+		HistoryLength: key.HistoryLength,
+		CWD:           key.CWD,
+		GitBranch:     key.GitBranch,
+		OSInfo:        key.OS,
+	}
+}
+
+// loadCache opens the cache database named by the configuration
+func loadCache() (*cache.Cache, *config.Config, error) {
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cacheDB, err := openCache(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open cache: %w", err)
+	}
+	return cacheDB, cfg, nil
+}
+
+// cacheFilter builds a filter from the --method, --cwd and --match flags
+func cacheFilter() (cache.Filter, error) {
+	filter := cache.Filter{Method: cacheMethod, Pattern: cacheMatch}
+	if cacheCWD != "" {
+		dir, err := filepath.Abs(cacheCWD)
+		if err != nil {
+			return filter, err
+		}
+		filter.CWD = dir
+	}
+	return filter, nil
+}
+
+func runCacheStats(cmd *cobra.Command, args []string) error {
+	cacheDB, _, err := loadCache()
+	if err != nil {
+		return err
+	}
+	defer cacheDB.Close()
+
+	summary, err := cacheDB.Summarize(cacheTop)
+	if err != nil {
+		return fmt.Errorf("cache stats: %w", err)
+	}
+
+	fmt.Println("Cache Statistics")
+	fmt.Println("================")
+	fmt.Println()
+	if summary.Expired > 0 {
+		fmt.Printf("  Entries:       %d (%d expired)\n", summary.Entries, summary.Expired)
+	} else {
+		fmt.Printf("  Entries:       %d\n", summary.Entries)
+	}
+	fmt.Printf("  Entry Hits:    %d\n", summary.TotalHits)
+	fmt.Printf("  Lookups:       %d\n", summary.Hits+summary.Misses)
+	if summary.Hits+summary.Misses > 0 {
+		fmt.Printf("  Hit Rate:      %.1f%% (%d hits, %d misses)\n",
+			summary.HitRate()*100, summary.Hits, summary.Misses)
+	}
+
+	if len(summary.Methods) > 0 {
+		fmt.Println()
+		fmt.Println("By Method:")
+		fmt.Println("----------")
+		for _, method := range summary.Methods {
+			fmt.Printf("%s:\n", method.Method)
+			fmt.Printf("  Entries:       %d\n", method.Entries)
+			if method.Hits+method.Misses > 0 {
+				fmt.Printf("  Hit Rate:      %.1f%% (%d hits, %d misses)\n",
+					method.HitRate()*100, method.Hits, method.Misses)
+			}
+		}
+	}
+
+	if len(summary.TopCommands) > 0 {
+		fmt.Println()
+		fmt.Println("Top Commands:")
+		fmt.Println("-------------")
+		for _, top := range summary.TopCommands {
+			fmt.Printf("  %6d  %s\n", top.Hits, top.Command)
+		}
+	}
+
+	if summary.Entries > 0 {
+		fmt.Println()
+		fmt.Println("Age Distribution:")
+		fmt.Println("-----------------")
+		for _, bucket := range summary.Ages {
+			fmt.Printf("  %-10s %6d\n", bucket.Label+":", bucket.Entries)
+		}
+	}
+	return nil
+}
+
+func runCacheList(cmd *cobra.Command, args []string) error {
+	cacheDB, _, err := loadCache()
+	if err != nil {
+		return err
+	}
+	defer cacheDB.Close()
+
+	filter, err := cacheFilter()
+	if err != nil {
+		return err
+	}
+	filter.Limit = cacheLimit
+
+	entries, err := cacheDB.List(filter)
+	if err != nil {
+		return fmt.Errorf("list cache: %w", err)
+	}
+	if len(entries) == 0 {
+		fmt.Println("No cache entries found.")
+		return nil
+	}
+
+	fmt.Printf("%-12s  %-8s  %6s  %9s  %s\n", "KEY", "METHOD", "HITS", "LAST USED", "COMMAND")
+	for _, entry := range entries {
+		fmt.Printf("%-12s  %-8s  %6d  %9s  %s\n", shortKey(entry.ContextHash), entry.Method,
+			entry.HitCount, formatAge(time.Since(entry.LastUsed)), entry.Command)
+	}
+	return nil
+}
+
+func runCacheShow(cmd *cobra.Command, args []string) error {
+	cacheDB, cfg, err := loadCache()
+	if err != nil {
+		return err
+	}
+	defer cacheDB.Close()
+
+	entry, err := cacheDB.Lookup(args[0])
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+
+	fmt.Printf("Key:        %s\n", entry.ContextHash)
+	fmt.Printf("Method:     %s\n", entry.Method)
+	fmt.Printf("Command:    %s\n", entry.Command)
+	if entry.CWD != "" {
+		fmt.Printf("Directory:  %s\n", entry.CWD)
+	}
+	if entry.GitBranch != "" {
+		fmt.Printf("Git Branch: %s\n", entry.GitBranch)
+	}
+	fmt.Printf("Created:    %s (%s ago)\n", entry.CreatedAt.Format(time.DateTime), formatAge(time.Since(entry.CreatedAt)))
+	fmt.Printf("Last Used:  %s (%s ago)\n", entry.LastUsed.Format(time.DateTime), formatAge(time.Since(entry.LastUsed)))
+	fmt.Printf("Hits:       %d\n", entry.HitCount)
+	if ttl := cfg.Cache.MethodTTL(entry.Method); ttl > 0 {
+		expires := entry.CreatedAt.Add(ttl)
+		fmt.Printf("Expires:    %s\n", expires.Format(time.DateTime))
+	}
+	return nil
+}
+
+func runCacheDelete(cmd *cobra.Command, args []string) error {
+	filter, err := cacheFilter()
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 && filter.IsZero() {
+		return errors.New("nothing to delete: give keys, --method, --cwd or --match")
+	}
+	if len(args) > 0 && !filter.IsZero() {
+		return errors.New("give either keys or --method/--cwd/--match, not both")
+	}
+
+	cacheDB, _, err := loadCache()
+	if err != nil {
+		return err
+	}
+	defer cacheDB.Close()
+
+	if len(args) == 0 {
+		removed, err := cacheDB.Delete(filter)
+		if err != nil {
+			return fmt.Errorf("delete cache entries: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Removed %d entries\n", removed)
+		return nil
+	}
+
+	// Resolve every key before deleting any, so a typo deletes nothing
+	keys := make([]string, 0, len(args))
+	for _, arg := range args {
+		entry, err := cacheDB.Lookup(arg)
+		if err != nil {
+			return fmt.Errorf("%s: %w", arg, err)
+		}
+		keys = append(keys, entry.ContextHash)
+	}
+
+	removed := 0
+	for _, key := range keys {
+		n, err := cacheDB.Delete(cache.Filter{KeyPrefix: key})
+		if err != nil {
+			return fmt.Errorf("delete cache entry: %w", err)
+		}
+		removed += n
+	}
+	fmt.Fprintf(os.Stderr, "Removed %d entries\n", removed)
+	return nil
+}
+
+func runCacheExport(cmd *cobra.Command, args []string) error {
+	cacheDB, _, err := loadCache()
+	if err != nil {
+		return err
+	}
+	defer cacheDB.Close()
+
+	entries, err := cacheDB.List(cache.Filter{})
+	if err != nil {
+		return fmt.Errorf("export cache: %w", err)
+	}
+
+	var out io.Writer = os.Stdout
+	if len(args) == 1 {
+		file, err := os.Create(args[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	w := bufio.NewWriter(out)
+	encoder := json.NewEncoder(w)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Exported %d entries\n", len(entries))
+	return nil
+}
+
+func runCacheImport(cmd *cobra.Command, args []string) error {
+	var in io.Reader = os.Stdin
+	if len(args) == 1 {
+		file, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+
+	entries, err := readCacheEntries(in)
+	if err != nil {
+		return err
+	}
+
+	cacheDB, _, err := loadCache()
+	if err != nil {
+		return err
+	}
+	defer cacheDB.Close()
+
+	imported, err := cacheDB.Import(entries)
+	if err != nil {
+		return fmt.Errorf("import cache: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Imported %d entries\n", imported)
+	return nil
+}
+
+// readCacheEntries reads entries written by export, one JSON object per line
+func readCacheEntries(r io.Reader) ([]cache.CacheEntry, error) {
+	var entries []cache.CacheEntry
+
+	decoder := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var entry cache.CacheEntry
+		err := decoder.Decode(&entry)
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", line, err)
+		}
+		if entry.ContextHash == "" || entry.Command == "" {
+			return nil, fmt.Errorf("entry %d: context_hash and command are required", line)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// shortKey abbreviates a context hash for listings
+func shortKey(key string) string {
+	if len(key) > 12 {
+		return key[:12]
+	}
+	return key
+}
+
+// formatAge formats a duration in its largest whole unit
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+}
+
+func runCachePrune(cmd *cobra.Command, args []string) error {
+	cacheDB, _, err := loadCache()
+	if err != nil {
+		return err
+	}
+	defer cacheDB.Close()
+
+	removed, err := cacheDB.Prune()
+	if err != nil {
+		return fmt.Errorf("prune cache: %w", err)
+	}
+
+	total, _, err := cacheDB.Stats()
+	if err != nil {
+		return fmt.Errorf("cache stats: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Removed %d entries, %d remaining\n", removed, total)
+	return nil
+}
@@ -5,6 +5,8 @@ import (
 	"os"
 	"path/filepath"
 
+	"llmsh/pkg/cache"
+
 	"github.com/spf13/cobra"
 )
 
@@ -13,12 +15,15 @@ var cleanAll bool
 var cleanCmd = &cobra.Command{
 	Use:   "clean",
 	Short: "Clean llmsh data",
-	Long:  `Remove logs, cache, and optionally all data except config.`,
-	RunE:  runClean,
+	Long: `Remove logs and cached commands, and optionally all data except config.
+
+Without --all, the cache database keeps the suggestions and feedback that
+'llmsh stats' reports acceptance rates from.`,
+	RunE: runClean,
 }
 
 func init() {
-	cleanCmd.Flags().BoolVarP(&cleanAll, "all", "a", false, "Remove all data including tokens (keeps config)")
+	cleanCmd.Flags().BoolVarP(&cleanAll, "all", "a", false, "Remove all data including tokens, suggestion feedback and the history model (keeps config)")
 }
 
 func runClean(cmd *cobra.Command, args []string) error {
@@ -32,7 +37,9 @@ func runClean(cmd *cobra.Command, args []string) error {
 	// Files to clean
 	logFile := "/tmp/llmsh_debug.log"
 	cacheDB := filepath.Join(llmshDir, "cache.db")
+	tokensDB := filepath.Join(llmshDir, "tokens.db")
 	tokensJSON := filepath.Join(llmshDir, "tokens.json")
+	historyDB := filepath.Join(llmshDir, "history.db")
 
 	cleaned := []string{}
 	errors := []error{}
@@ -46,24 +53,52 @@ func runClean(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	// Always clean cache
-	if fileExists(cacheDB) {
-		if err := removeIfExists(cacheDB); err != nil {
+	// Always clean cached commands; the feedback on suggestions stored
+	// alongside them goes with the database only when cleaning everything
+	if fileExists(cacheDB) && !cleanAll {
+		if err := clearCache(cacheDB); err != nil {
 			errors = append(errors, fmt.Errorf("cache: %w", err))
 		} else {
-			cleaned = append(cleaned, "cache database")
+			cleaned = append(cleaned, "cached commands")
 		}
 	}
 
 	// Clean tokens if -a flag is set
 	if cleanAll {
-		if fileExists(tokensJSON) {
-			if err := removeIfExists(tokensJSON); err != nil {
+		if fileExists(cacheDB) {
+			var err error
+			for _, path := range []string{cacheDB, cacheDB + "-wal", cacheDB + "-shm"} {
+				if removeErr := removeIfExists(path); removeErr != nil {
+					err = removeErr
+				}
+			}
+			if err != nil {
+				errors = append(errors, fmt.Errorf("cache: %w", err))
+			} else {
+				cleaned = append(cleaned, "cache database, with suggestion feedback")
+			}
+		}
+		// Also remove the SQLite journal files and a legacy JSON store
+		if fileExists(tokensDB) || fileExists(tokensJSON) {
+			var err error
+			for _, path := range []string{tokensDB, tokensDB + "-wal", tokensDB + "-shm", tokensJSON, tokensJSON + ".migrated"} {
+				if removeErr := removeIfExists(path); removeErr != nil {
+					err = removeErr
+				}
+			}
+			if err != nil {
 				errors = append(errors, fmt.Errorf("tokens: %w", err))
 			} else {
 				cleaned = append(cleaned, "token tracking data")
 			}
 		}
+		if fileExists(historyDB) {
+			if err := removeIfExists(historyDB); err != nil {
+				errors = append(errors, fmt.Errorf("history: %w", err))
+			} else {
+				cleaned = append(cleaned, "offline history model")
+			}
+		}
 	}
 
 	// Report results
@@ -87,6 +122,19 @@ func runClean(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// clearCache removes the cached commands of a cache database, keeping the
+// suggestions and their feedback
+func clearCache(path string) error {
+	cacheDB, err := cache.Open(path, cache.Options{})
+	if err != nil {
+		return err
+	}
+	defer cacheDB.Close()
+
+	_, err = cacheDB.Clear()
+	return err
+}
+
 func fileExists(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil
@@ -1,10 +1,10 @@
 package cmd
 
 import (
+	stdcontext "context"
 	"fmt"
 	"strings"
 
-	"llmsh/pkg/config"
 	"llmsh/pkg/context"
 	"llmsh/pkg/llm"
 	"llmsh/pkg/tracker"
@@ -15,75 +15,115 @@ import (
 var completeCmd = &cobra.Command{
 	Use:   "complete",
 	Short: "Complete a partial command",
-	Long:  `Reads a partial command and context from stdin and completes it.`,
-	RunE:  runComplete,
+	Long: `Reads a partial command and context from stdin, or from flags or the
+environment with --input, and completes it.`,
+	RunE: runComplete,
 }
 
-func runComplete(cmd *cobra.Command, args []string) error {
-	// Read request from stdin
-	req, err := readRequest()
-	if err != nil {
-		writeError(err.Error())
-		return err
-	}
+func init() {
+	addRequestFlags(completeCmd, "prefix", "candidates")
+}
 
-	// Load configuration
-	cfg, err := config.Load()
-	if err != nil {
-		writeError(fmt.Sprintf("load config: %v", err))
-		return err
-	}
+func runComplete(cmd *cobra.Command, args []string) error {
+	return runOneShot(cmd.Context(), "complete", false)
+}
 
+// complete serves a partial command completion request
+func (h *handler) complete(ctx stdcontext.Context, req *Request) (*Response, error) {
 	// Check minimum prefix length
-	if len(req.Prefix) < cfg.Prediction.MinPrefixLength {
-		writeError("prefix too short")
-		return fmt.Errorf("prefix too short")
+	if len(req.Prefix) < h.cfg.Prediction.MinPrefixLength {
+		return &Response{Error: "prefix too short"}, fmt.Errorf("prefix too short")
 	}
 
 	// Filter sensitive information
 	filteredHistory := context.FilterSensitive(req.History)
+	h.learn(filteredHistory, req)
+
+	// Check cache if enabled; asking for alternatives bypasses it since only
+	// the top command is cached
+	cacheKey := h.cacheKey("complete", req.Prefix, filteredHistory, req)
+	n := candidateCount(req)
+	if n == 1 {
+		if cached := h.cachedResult("complete", cacheKey); cached != nil {
+			return &Response{Result: cached}, nil
+		}
+	}
+
+	// Answer instantly from the offline predictor when it is confident
+	if h.cfg.Offline.FirstPass {
+		if offline := h.offlinePredict(filteredHistory, req, n, h.cfg.Offline.MinConfidence); offline != nil {
+			return &Response{Result: offline}, nil
+		}
+	}
 
 	// Build prompt
-	prompt := buildCompletePrompt(req.Prefix, filteredHistory, req.CWD, req.OSInfo)
+	prompt := buildCompletePrompt(req.Prefix, h.promptHistory(filteredHistory), req.CWD, req.OSInfo)
 
 	// Call LLM
-	client := llm.NewClient(cfg.LLM)
-	result, err := client.Complete(prompt)
+	result, err := h.client.Complete(ctx, &llm.Request{
+		System: completeInstructions,
+		Prompt: prompt,
+		N:      n,
+		Shell:  shellContext(filteredHistory, req),
+	})
 	if err != nil {
-		// Fail silently for LLM errors
-		return err
+		// Over budget, answer from the cache
+		if resp := h.budgetFallback(err, "complete", cacheKey); resp != nil {
+			return resp, nil
+		}
+		// Fall back to the offline predictor, e.g. when the network is down
+		if offline := h.offlineFallback(err, filteredHistory, req, n); offline != nil {
+			return &Response{Result: offline, ErrorCode: errorCode(err)}, nil
+		}
+		// Fail silently for LLM errors other than timeouts and cancellation
+		return llmErrorResponse(err), err
 	}
 
+	completeResult := newPredictResult(result, n)
+
+	// Save to cache
+	h.cacheResult("complete", cacheKey, req, completeResult.Command, nil)
+
 	// Record token usage
-	if cfg.Tracking.Enabled {
+	if h.cfg.Tracking.Enabled {
 		tracker.RecordUsage(&tracker.Record{
-			Method:       "complete",
-			Provider:     cfg.LLM.DefaultProvider,
-			Model:        result.Model,
-			InputTokens:  result.Usage.InputTokens,
-			OutputTokens: result.Usage.OutputTokens,
+			Method:              "complete",
+			Provider:            result.Provider,
+			Model:               result.Model,
+			InputTokens:         result.Usage.InputTokens,
+			OutputTokens:        result.Usage.OutputTokens,
+			CacheCreationTokens: result.Usage.CacheCreationTokens,
+			CacheReadTokens:     result.Usage.CacheReadTokens,
 		})
 	}
 
-	// Write response
-	writeResponse(&Response{
-		Result: &PredictResult{
-			Command: result.Command,
-			Cached:  false,
-		},
+	return &Response{
+		Result: completeResult,
 		Tokens: &TokenUsage{
-			InputTokens:  result.Usage.InputTokens,
-			OutputTokens: result.Usage.OutputTokens,
+			InputTokens:         result.Usage.InputTokens,
+			OutputTokens:        result.Usage.OutputTokens,
+			CacheCreationTokens: result.Usage.CacheCreationTokens,
+			CacheReadTokens:     result.Usage.CacheReadTokens,
 		},
-	})
-
-	return nil
+	}, nil
 }
 
+// completeInstructions are the system instructions of completion prompts,
+// asking for the whole command the prefix starts
+const completeInstructions = `You are a shell command completion assistant.
+
+Complete the partial command to a full, valid command, given the context of the user's shell session.
+Rules:
+- Return ONLY the completed command
+- Ensure it starts with or relates to the given prefix
+- Be practical and safe
+- Do not include markdown code blocks`
+
+// buildCompletePrompt builds the part of a completion prompt that changes
+// between requests
 func buildCompletePrompt(prefix string, history []string, cwd, osInfo string) string {
 	var sb strings.Builder
 
-	sb.WriteString("You are a shell command completion assistant.\n\n")
 	sb.WriteString("Context:\n")
 	if osInfo != "" {
 		sb.WriteString(fmt.Sprintf("- OS: %s\n", osInfo))
@@ -93,23 +133,12 @@ func buildCompletePrompt(prefix string, history []string, cwd, osInfo string) st
 
 	if len(history) > 0 {
 		sb.WriteString("- Recent commands:\n")
-		// Show last 5 commands
-		start := len(history) - 5
-		if start < 0 {
-			start = 0
-		}
-		for i := start; i < len(history); i++ {
-			sb.WriteString(fmt.Sprintf("  %d. %s\n", i-start+1, history[i]))
+		for i, entry := range history {
+			sb.WriteString(fmt.Sprintf("  %d. %s\n", i+1, entry))
 		}
 	}
 
-	sb.WriteString("\nComplete the partial command to a full, valid command.\n")
-	sb.WriteString("Rules:\n")
-	sb.WriteString("- Return ONLY the completed command\n")
-	sb.WriteString("- Ensure it starts with or relates to the given prefix\n")
-	sb.WriteString("- Be practical and safe\n")
-	sb.WriteString("- Do not include markdown code blocks\n\n")
-	sb.WriteString("Completed command:")
+	sb.WriteString("\nCompleted command:")
 
 	return sb.String()
 }
@@ -9,6 +9,9 @@ import (
 	"github.com/spf13/viper"
 )
 
+// anthropicModel is the model of the Anthropic provider in the default config
+const anthropicModel = "claude-3-5-haiku-latest"
+
 var configCmd = &cobra.Command{
 	Use:   "config",
 	Short: "Manage llmsh configuration",
@@ -62,38 +65,109 @@ func runConfigInit(cmd *cobra.Command, args []string) error {
 
 	// Set default LLM configuration
 	v.Set("llm.default_provider", "openai")
+	v.Set("llm.fallback_providers", []string{"local"})
+
+	// Per-method deadlines across the whole provider chain
+	v.Set("llm.timeouts.predict", "5s")
+	v.Set("llm.timeouts.complete", "5s")
+	v.Set("llm.timeouts.nl2cmd", "30s")
+	v.Set("llm.timeouts.explain", "30s")
+	v.Set("llm.timeouts.embed", "5s")
 
 	// OpenAI provider
+	v.Set("llm.providers.openai.type", "openai")
 	v.Set("llm.providers.openai.base_url", "https://api.openai.com/v1")
 	v.Set("llm.providers.openai.api_key", "${OPENAI_API_KEY}")
 	v.Set("llm.providers.openai.model", "gpt-4-turbo-preview")
 	v.Set("llm.providers.openai.max_tokens", 100)
 	v.Set("llm.providers.openai.temperature", 0.2)
+	v.Set("llm.providers.openai.timeout", "10s")
+
+	// Anthropic provider (native Messages API); its prompt caching only
+	// takes effect with llm.instructions long enough for the model
+	v.Set("llm.providers.anthropic.type", "anthropic")
+	v.Set("llm.providers.anthropic.base_url", "https://api.anthropic.com")
+	v.Set("llm.providers.anthropic.api_key", "${ANTHROPIC_API_KEY}")
+	v.Set("llm.providers.anthropic.model", anthropicModel)
+	v.Set("llm.providers.anthropic.max_tokens", 100)
+	v.Set("llm.providers.anthropic.temperature", 0.2)
+	v.Set("llm.providers.anthropic.prompt_caching", true)
+	v.Set("llm.providers.anthropic.timeout", "10s")
 
 	// Local provider (Ollama)
+	v.Set("llm.providers.local.type", "openai")
 	v.Set("llm.providers.local.base_url", "http://localhost:11434/v1")
 	v.Set("llm.providers.local.api_key", "")
 	v.Set("llm.providers.local.model", "codellama:7b")
 	v.Set("llm.providers.local.max_tokens", 100)
 	v.Set("llm.providers.local.temperature", 0.2)
+	v.Set("llm.providers.local.timeout", "30s")
 
 	// Prediction settings
 	v.Set("prediction.history_length", 20)
 	v.Set("prediction.min_prefix_length", 3)
+	v.Set("prediction.max_line_tokens", 200)
 
 	// Cache settings
 	v.Set("cache.enabled", true)
 	v.Set("cache.db_path", "~/.llmsh/cache.db")
 	v.Set("cache.ttl_days", 7)
 	v.Set("cache.max_entries", 1000)
+	v.Set("cache.methods.predict.enabled", true)
+	v.Set("cache.methods.predict.key", map[string]interface{}{
+		"history_length": 3, "cwd": true, "git_branch": true, "os": false,
+	})
+	v.Set("cache.methods.complete.enabled", true)
+	v.Set("cache.methods.complete.key", map[string]interface{}{
+		"history_length": 0, "cwd": true, "git_branch": false, "os": true,
+	})
+	v.Set("cache.methods.nl2cmd.enabled", true)
+	v.Set("cache.methods.nl2cmd.ttl_days", 30)
+	v.Set("cache.methods.nl2cmd.key", map[string]interface{}{
+		"history_length": 0, "cwd": true, "git_branch": false, "os": true,
+	})
+	v.Set("cache.semantic.enabled", false)
+	v.Set("cache.semantic.provider", "openai")
+	v.Set("cache.semantic.model", "text-embedding-3-small")
+	v.Set("cache.semantic.threshold", 0.9)
 
 	// Tracking settings
 	v.Set("tracking.enabled", true)
-	v.Set("tracking.db_path", "~/.llmsh/tokens.json")
-
-	// ZSH keybindings
-	v.Set("zsh.keybindings.accept_prediction", "^I")
+	v.Set("tracking.db_path", "~/.llmsh/tokens.db")
+
+	// Spend caps checked before each LLM call; zero means no limit
+	v.Set("tracking.budget.daily.tokens", 0)
+	v.Set("tracking.budget.daily.cost", 0.0)
+	v.Set("tracking.budget.monthly.tokens", 0)
+	v.Set("tracking.budget.monthly.cost", 0.0)
+
+	// Prices in dollars per million tokens, used by 'llmsh stats'
+	v.Set("pricing", []map[string]interface{}{
+		{"provider": "openai", "model": "gpt-4-turbo", "input": 10.0, "output": 30.0},
+		{"provider": "openai", "model": "gpt-4o", "input": 2.5, "output": 10.0, "cached_input": 1.25},
+		{"provider": "openai", "model": "gpt-4o-mini", "input": 0.15, "output": 0.6, "cached_input": 0.075},
+		{"provider": "openai", "model": "text-embedding-3-small", "input": 0.02},
+		{"provider": "anthropic", "model": "claude-3-5-haiku", "input": 0.8, "output": 4.0, "cached_input": 0.08, "cache_write": 1.0},
+		{"provider": "local", "model": "", "input": 0.0, "output": 0.0},
+	})
+
+	// Local checks of generated commands
+	v.Set("safety.action", "warn")
+	v.Set("safety.level", "high")
+
+	// Offline history-based predictor
+	v.Set("offline.enabled", true)
+	v.Set("offline.db_path", "~/.llmsh/history.db")
+	v.Set("offline.first_pass", true)
+	v.Set("offline.min_confidence", 0.6)
+
+	// Daemon settings
+	v.Set("daemon.socket_path", "~/.llmsh/llmsh.sock")
+
+	// Keybindings of every shell, in zsh bindkey notation
 	v.Set("zsh.keybindings.nl2cmd", "^[^M")
+	v.Set("zsh.keybindings.predict", "^O")
+	v.Set("zsh.keybindings.explain", "^[e")
 
 	// Write config file
 	if err := v.WriteConfig(); err != nil {
@@ -105,10 +179,15 @@ func runConfigInit(cmd *cobra.Command, args []string) error {
 	fmt.Fprintf(os.Stderr, "1. Set your OpenAI API key:\n")
 	fmt.Fprintf(os.Stderr, "   export OPENAI_API_KEY=\"your-api-key\"\n")
 	fmt.Fprintf(os.Stderr, "   Or edit %s and replace ${OPENAI_API_KEY}\n\n", configFile)
-	fmt.Fprintf(os.Stderr, "2. Alternatively, configure a local LLM provider (like Ollama)\n")
-	fmt.Fprintf(os.Stderr, "   by changing 'default_provider' to 'local' in the config\n\n")
+	fmt.Fprintf(os.Stderr, "2. Alternatively, use Claude by exporting ANTHROPIC_API_KEY and changing\n")
+	fmt.Fprintf(os.Stderr, "   'default_provider' to 'anthropic', or configure a local LLM provider\n")
+	fmt.Fprintf(os.Stderr, "   (like Ollama) by changing 'default_provider' to 'local' in the config\n\n")
+	fmt.Fprintf(os.Stderr, "   Note: prompt caching stays off with %s. Haiku models\n", anthropicModel)
+	fmt.Fprintf(os.Stderr, "   only cache system prompts of 2048 tokens or more, and the built-in\n")
+	fmt.Fprintf(os.Stderr, "   instructions are shorter; it takes effect once 'llm.instructions'\n")
+	fmt.Fprintf(os.Stderr, "   extends them\n\n")
 	fmt.Fprintf(os.Stderr, "3. Load the ZSH plugin by adding to your ~/.zshrc:\n")
-	fmt.Fprintf(os.Stderr, "   source /path/to/llmsh/zsh/llmsh.plugin.zsh\n")
+	fmt.Fprintf(os.Stderr, "   eval \"$(llmsh init zsh)\"\n")
 
 	return nil
 }
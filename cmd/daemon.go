@@ -0,0 +1,334 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"llmsh/pkg/config"
+	"llmsh/pkg/llm"
+
+	"github.com/spf13/cobra"
+)
+
+// connIdleTimeout bounds how long a client connection may stay idle
+const connIdleTimeout = 2 * time.Minute
+
+var daemonSocket string
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Run llmsh as a long-running daemon",
+	Long: `Serve predict, complete and nl2cmd requests over a Unix socket.
+
+The daemon keeps the configuration, cache database, token tracker and LLM
+connections warm between calls. Each request is a single line of JSON in the
+same format accepted on stdin by the one-shot subcommands, and each response is
+written back as a single line of JSON. A streamed nl2cmd request is first
+answered with a line per chunk of the command, as with 'nl2cmd --stream'.
+
+Requests setting "output": "sh" are answered with the typeset statements of
+'--output sh' instead, ending with an empty line, for shells that talk to
+the socket directly.`,
+	RunE: runDaemon,
+}
+
+func init() {
+	daemonCmd.Flags().StringVarP(&daemonSocket, "socket", "s", "", "Unix socket path (default from daemon.socket_path)")
+}
+
+func runDaemon(cmd *cobra.Command, args []string) error {
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	socketPath := cfg.Daemon.SocketPath
+	if daemonSocket != "" {
+		socketPath = daemonSocket
+	}
+
+	listener, err := listenUnix(socketPath)
+	if err != nil {
+		return err
+	}
+
+	h := newHandler(cfg)
+	defer h.Close()
+
+	// Stop accepting on SIGINT/SIGTERM; closing the listener removes the socket
+	ctx := cmd.Context()
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	fmt.Fprintf(os.Stderr, "llmsh daemon listening on %s\n", socketPath)
+
+	var wg sync.WaitGroup
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				break
+			}
+			return fmt.Errorf("accept: %w", err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serveConn(ctx, h, conn)
+		}()
+	}
+
+	// Let in-flight requests wind down before closing the cache
+	wg.Wait()
+	fmt.Fprintf(os.Stderr, "llmsh daemon stopped\n")
+	return nil
+}
+
+// listenUnix listens on a Unix socket, replacing a stale socket file left
+// behind by a daemon that did not shut down cleanly
+func listenUnix(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("create socket directory: %w", err)
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("daemon already running on %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("remove stale socket: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("listen on %s: %w", path, err)
+	}
+
+	// Only the owner may talk to the daemon
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("chmod socket: %w", err)
+	}
+
+	return listener, nil
+}
+
+// serveConn answers newline-delimited JSON requests until the client closes
+// the connection
+func serveConn(ctx context.Context, h *handler, conn net.Conn) {
+	defer conn.Close()
+
+	var reader io.Reader = conn
+	for {
+		conn.SetDeadline(time.Now().Add(connIdleTimeout))
+
+		decoder := json.NewDecoder(reader)
+		var req Request
+		if err := decoder.Decode(&req); err != nil {
+			if !errors.Is(err, io.EOF) {
+				encodeResponse(conn, &Response{Error: fmt.Sprintf("invalid JSON input: %v", err)})
+			}
+			return
+		}
+
+		// Abort the request if the client hangs up, e.g. on Ctrl+C in the
+		// shell. Clients that already sent the next request are not watched.
+		buffered, _ := io.ReadAll(decoder.Buffered())
+		reqCtx, cancel := context.WithCancel(ctx)
+		var pending []byte
+		stopWatch := func() {}
+		if len(bytes.TrimSpace(buffered)) == 0 {
+			stopWatch = watchHangup(conn, cancel, &pending)
+		}
+
+		format := req.Output
+		if format == "" {
+			format = OutputJSON
+		}
+		// Shells send the working directory alone, as to the one-shot
+		// commands
+		if format == OutputSh {
+			addShellContext(&req)
+		}
+		if req.Stream {
+			req.emit = func(chunk *StreamChunk) {
+				writeChunk(conn, format, chunk)
+			}
+		}
+
+		var resp *Response
+		var err error
+		if format == OutputJSON || format == OutputSh {
+			resp, err = h.handle(reqCtx, &req)
+		} else {
+			err = fmt.Errorf("unknown output format %q: use json or sh", req.Output)
+			format = OutputJSON
+		}
+		stopWatch()
+		cancel()
+
+		// Keep whatever the client sent ahead for the next request
+		reader = io.MultiReader(bytes.NewReader(buffered), bytes.NewReader(pending), conn)
+		if resp == nil {
+			// Unlike the one-shot commands, a socket client always gets an answer
+			resp = &Response{}
+			if err != nil {
+				resp.Error = err.Error()
+			}
+		}
+
+		if err := writeSocketResponse(conn, format, req.Method, resp); err != nil {
+			return
+		}
+	}
+}
+
+// writeSocketResponse writes the response to a socket request in its output
+// format, ending an sh response with an empty line
+func writeSocketResponse(w io.Writer, format, method string, resp *Response) error {
+	if format != OutputSh {
+		return encodeResponse(w, resp)
+	}
+	if err := writeOutput(w, OutputSh, method, resp); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// watchHangup cancels a request when the client closes the connection while
+// the request is in flight. Bytes the client sends meanwhile are stored in
+// pending. The returned function stops watching.
+func watchHangup(conn net.Conn, cancel context.CancelFunc, pending *[]byte) func() {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 512)
+		n, err := conn.Read(buf)
+		*pending = buf[:n]
+		if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			cancel()
+		}
+	}()
+
+	return func() {
+		// Unblock the pending read
+		conn.SetReadDeadline(time.Now())
+		<-done
+	}
+}
+
+// forwardGrace is how much longer than a method's timeout a one-shot command
+// waits for the daemon, so that the daemon reports the timeout itself
+const forwardGrace = 5 * time.Second
+
+// forwardRequest lets a running daemon serve a one-shot command's request,
+// with its warm caches and connections, passing streamed chunks to the
+// request's emit function. It returns false when no daemon answered, for the
+// request to be served in process, unless chunks were already passed on:
+// serving the request again would show the command anew, so the lost
+// connection is reported instead.
+func forwardRequest(ctx context.Context, cfg *config.Config, req *Request) (*Response, bool) {
+	conn, err := net.DialTimeout("unix", cfg.Daemon.SocketPath, time.Second)
+	if err != nil {
+		return nil, false
+	}
+	defer conn.Close()
+
+	// Hanging up cancels the request in the daemon
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if timeout := cfg.LLM.Timeout(req.Method); timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout + forwardGrace))
+	}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, false
+	}
+
+	reader := bufio.NewReader(conn)
+	emitted := false
+	lost := func(err error) (*Response, bool) {
+		if !emitted {
+			return nil, false
+		}
+		return &Response{Error: fmt.Sprintf("daemon connection lost: %v", err)}, true
+	}
+	for {
+		line, err := reader.ReadBytes('\n')
+		switch {
+		case err == nil:
+		case ctx.Err() != nil:
+			return &Response{Error: llm.ErrCanceled.Error(), ErrorCode: ErrorCodeCanceled}, true
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			return &Response{Error: llm.ErrTimeout.Error(), ErrorCode: ErrorCodeTimeout}, true
+		default:
+			// The daemon went away; serving the request again is harmless
+			// until part of the answer was shown
+			return lost(err)
+		}
+
+		if bytes.HasPrefix(line, []byte(`{"partial":`)) {
+			var chunk StreamChunk
+			if err := json.Unmarshal(line, &chunk); err == nil && req.emit != nil {
+				req.emit(&chunk)
+				emitted = true
+			}
+			continue
+		}
+
+		resp, err := decodeResponse(req.Method, line)
+		if err != nil {
+			return lost(err)
+		}
+		return resp, true
+	}
+}
+
+// decodeResponse decodes a response written by the daemon into the result
+// type of its method
+func decodeResponse(method string, line []byte) (*Response, error) {
+	var raw struct {
+		Response
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(line, &raw); err != nil {
+		return nil, fmt.Errorf("invalid daemon response: %w", err)
+	}
+
+	resp := raw.Response
+	if len(raw.Result) == 0 || string(raw.Result) == "null" {
+		return &resp, nil
+	}
+
+	var result interface{}
+	switch method {
+	case "explain":
+		result = &ExplainResult{}
+	case "feedback":
+		result = &FeedbackResult{}
+	default:
+		result = &PredictResult{}
+	}
+	if err := json.Unmarshal(raw.Result, result); err != nil {
+		return nil, fmt.Errorf("invalid daemon response: %w", err)
+	}
+	resp.Result = result
+	return &resp, nil
+}
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"llmsh/pkg/config"
+)
+
+// fakeDaemon answers one request on a Unix socket with the given lines, then
+// hangs up
+func fakeDaemon(t *testing.T, lines ...string) *config.Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "llmsh.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		bufio.NewReader(conn).ReadBytes('\n')
+		for _, line := range lines {
+			conn.Write([]byte(line + "\n"))
+		}
+	}()
+
+	return &config.Config{Daemon: config.DaemonConfig{SocketPath: path}}
+}
+
+func TestForwardRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		lines       []string
+		wantOK      bool
+		wantPartial int
+		wantCommand string
+	}{
+		{
+			name:        "response",
+			lines:       []string{`{"result":{"command":"ls","cached":false}}`},
+			wantOK:      true,
+			wantCommand: "ls",
+		},
+		{
+			name:        "streamed response",
+			lines:       []string{`{"partial":"l"}`, `{"partial":"ls"}`, `{"result":{"command":"ls","cached":false}}`},
+			wantOK:      true,
+			wantPartial: 2,
+			wantCommand: "ls",
+		},
+		{
+			name:   "hangup before answering",
+			wantOK: false,
+		},
+		{
+			name:        "hangup after a chunk",
+			lines:       []string{`{"partial":"l"}`},
+			wantOK:      true,
+			wantPartial: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := fakeDaemon(t, tt.lines...)
+			partials := 0
+			req := &Request{Method: "nl2cmd", Description: "list", Stream: true}
+			req.emit = func(*StreamChunk) { partials++ }
+
+			resp, ok := forwardRequest(context.Background(), cfg, req)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if partials != tt.wantPartial {
+				t.Errorf("emitted %d chunks, want %d", partials, tt.wantPartial)
+			}
+			if !ok {
+				return
+			}
+			if tt.wantCommand == "" {
+				if resp.Error == "" {
+					t.Error("lost connection after a chunk was not reported")
+				}
+				return
+			}
+			result, _ := resp.Result.(*PredictResult)
+			if result == nil || result.Command != tt.wantCommand {
+				t.Errorf("result = %+v, want command %q", resp.Result, tt.wantCommand)
+			}
+		})
+	}
+}
+
+func TestWriteSocketResponse(t *testing.T) {
+	resp := &Response{Result: &PredictResult{Command: "ls"}}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: OutputJSON,
+			want:   `{"result":{"command":"ls","cached":false}}` + "\n",
+		},
+		{
+			format: OutputSh,
+			want: "typeset llmsh_error=$''\n" +
+				"typeset llmsh_error_code=$''\n" +
+				"typeset llmsh_command=$'ls'\n" +
+				"typeset -a llmsh_candidates=($'ls')\n" +
+				"typeset -a llmsh_candidate_risks=($'-')\n" +
+				"typeset llmsh_risk=$''\n" +
+				"typeset -a llmsh_risk_reasons=()\n" +
+				"typeset llmsh_action=$''\n" +
+				"typeset llmsh_suggestion_id=$''\n" +
+				"\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var b strings.Builder
+			if err := writeSocketResponse(&b, tt.format, "predict", resp); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", b.String(), tt.want)
+			}
+		})
+	}
+}
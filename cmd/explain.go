@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"llmsh/pkg/config"
+	shellctx "llmsh/pkg/context"
+	"llmsh/pkg/llm"
+	"llmsh/pkg/safety"
+	"llmsh/pkg/tracker"
+
+	"github.com/spf13/cobra"
+)
+
+// ExplainResult describes what a command does
+type ExplainResult struct {
+	Command string `json:"command"`
+	Summary string `json:"summary"`
+	// Arguments explains the program and each of its arguments in order
+	Arguments []ArgumentExplanation `json:"arguments"`
+	// SideEffects lists what running the command changes, if anything
+	SideEffects []string `json:"side_effects"`
+	// Risk rates the harm running the command could do: low, medium or high
+	Risk     string `json:"risk"`
+	Source   string `json:"source,omitempty"`
+	Provider string `json:"provider,omitempty"`
+	Model    string `json:"model,omitempty"`
+}
+
+// ArgumentExplanation explains one word of a command line
+type ArgumentExplanation struct {
+	Argument    string `json:"argument"`
+	Explanation string `json:"explanation"`
+}
+
+// explainMaxTokens leaves room for an explanation in the answer, which is
+// much longer than a command
+const explainMaxTokens = 800
+
+// errInvalidExplanation is returned when the LLM answer is not an explanation
+var errInvalidExplanation = errors.New("invalid explanation")
+
+var explainCmd = &cobra.Command{
+	Use:   "explain",
+	Short: "Explain what a shell command does",
+	Long: `Reads a command line and context from stdin, or from flags or the
+environment with --input, and describes what the command does: a summary, an
+explanation of each argument, its side effects and how risky it is to run.`,
+	RunE: runExplain,
+}
+
+func init() {
+	addRequestFlags(explainCmd, "command")
+}
+
+func runExplain(cmd *cobra.Command, args []string) error {
+	return runOneShot(cmd.Context(), "explain", false)
+}
+
+// explain serves a request to describe a command
+func (h *handler) explain(ctx context.Context, req *Request) (*Response, error) {
+	// Validate command
+	if strings.TrimSpace(req.Command) == "" {
+		return &Response{Error: "command is required"}, fmt.Errorf("command is required")
+	}
+
+	// Filter sensitive information; the command is sent as well
+	command := shellctx.FilterSensitive([]string{req.Command})[0]
+	filteredHistory := shellctx.FilterSensitive(req.History)
+
+	// Build prompt
+	prompt := buildExplainPrompt(command, req.CWD, h.promptHistory(filteredHistory), req.OSInfo)
+
+	// Call LLM
+	result, err := h.client.Explain(ctx, &llm.Request{
+		System:    explainInstructions,
+		Prompt:    prompt,
+		MaxTokens: explainMaxTokens,
+	})
+	if err != nil {
+		// Fail silently for LLM errors other than timeouts and cancellation
+		return llmErrorResponse(err), err
+	}
+
+	// Record token usage, even if the answer turns out to be unusable
+	if h.cfg.Tracking.Enabled {
+		tracker.RecordUsage(&tracker.Record{
+			Method:              "explain",
+			Provider:            result.Provider,
+			Model:               result.Model,
+			InputTokens:         result.Usage.InputTokens,
+			OutputTokens:        result.Usage.OutputTokens,
+			CacheCreationTokens: result.Usage.CacheCreationTokens,
+			CacheReadTokens:     result.Usage.CacheReadTokens,
+		})
+	}
+
+	explanation, err := parseExplanation(result.Command)
+	if err != nil {
+		return &Response{Error: err.Error()}, err
+	}
+	explanation.Command = req.Command
+	// The local safety rules can only raise the risk the LLM saw
+	if h.cfg.Safety.Action != config.SafetyActionOff {
+		explanation.Risk = safety.Max(explanation.Risk, safety.Check(req.Command).Risk)
+	}
+	explanation.Source = SourceLLM
+	explanation.Provider = result.Provider
+	explanation.Model = result.Model
+
+	return &Response{
+		Result: explanation,
+		Tokens: &TokenUsage{
+			InputTokens:         result.Usage.InputTokens,
+			OutputTokens:        result.Usage.OutputTokens,
+			CacheCreationTokens: result.Usage.CacheCreationTokens,
+			CacheReadTokens:     result.Usage.CacheReadTokens,
+		},
+	}, nil
+}
+
+// parseExplanation decodes the JSON object of an answer, ignoring any text
+// around it, and normalizes its risk level
+func parseExplanation(answer string) (*ExplainResult, error) {
+	start := strings.IndexByte(answer, '{')
+	end := strings.LastIndexByte(answer, '}')
+	if start < 0 || end < start {
+		return nil, errInvalidExplanation
+	}
+
+	var explanation ExplainResult
+	if err := json.Unmarshal([]byte(answer[start:end+1]), &explanation); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidExplanation, err)
+	}
+	if explanation.Summary == "" {
+		return nil, errInvalidExplanation
+	}
+
+	// An unrated command is not assumed to be harmless
+	explanation.Risk = strings.ToLower(strings.TrimSpace(explanation.Risk))
+	if !safety.Valid(explanation.Risk) {
+		explanation.Risk = safety.Medium
+	}
+	if explanation.Arguments == nil {
+		explanation.Arguments = []ArgumentExplanation{}
+	}
+	if explanation.SideEffects == nil {
+		explanation.SideEffects = []string{}
+	}
+
+	return &explanation, nil
+}
+
+// explainInstructions are the system instructions of explanation prompts,
+// asking for the JSON object decoded into an ExplainResult
+const explainInstructions = `You are a shell command explainer.
+
+Task: Explain what the given shell command does.
+Respond with a single JSON object with these fields:
+- "summary": one sentence describing what the command does
+- "arguments": an array of {"argument", "explanation"} objects, one for the program and each argument, in order
+- "side_effects": an array of short descriptions of what running the command changes (files, processes, network, system state); empty if nothing
+- "risk": "low" for read-only commands, "medium" for commands that change state in a recoverable way, "high" for destructive or irreversible ones
+Rules:
+- Return ONLY the JSON object, no other text
+- Keep every explanation short
+- Do not include markdown code blocks`
+
+// buildExplainPrompt builds the part of an explanation prompt that changes
+// between requests
+func buildExplainPrompt(command, cwd string, history []string, osInfo string) string {
+	var sb strings.Builder
+
+	sb.WriteString("Context:\n")
+	if osInfo != "" {
+		sb.WriteString(fmt.Sprintf("- OS: %s\n", osInfo))
+	}
+	if cwd != "" {
+		sb.WriteString(fmt.Sprintf("- Current directory: %s\n", cwd))
+	}
+
+	if len(history) > 0 {
+		sb.WriteString("- Recent commands (for context):\n")
+		for i, entry := range history {
+			sb.WriteString(fmt.Sprintf("  %d. %s\n", i+1, entry))
+		}
+	}
+
+	sb.WriteString(fmt.Sprintf("- Command: %s\n", command))
+
+	sb.WriteString("\nJSON:")
+
+	return sb.String()
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"llmsh/pkg/cache"
+
+	"github.com/spf13/cobra"
+)
+
+// FeedbackResult represents the recorded outcome of a suggestion
+type FeedbackResult struct {
+	Outcome string `json:"outcome"`
+}
+
+var feedbackCmd = &cobra.Command{
+	Use:   "feedback",
+	Short: "Record whether a suggestion was used",
+	Long: `Reads a suggestion ID and the command line the user executed from stdin as
+JSON, or from flags or the environment with --input, and records whether the
+suggestion was accepted, edited or discarded.`,
+	RunE: runFeedback,
+}
+
+func init() {
+	addRequestFlags(feedbackCmd, "feedback")
+}
+
+func runFeedback(cmd *cobra.Command, args []string) error {
+	return runOneShot(cmd.Context(), "feedback", false)
+}
+
+// feedback serves a request reporting what happened to a suggestion
+func (h *handler) feedback(ctx context.Context, req *Request) (*Response, error) {
+	if req.SuggestionID == 0 {
+		return &Response{Error: "suggestion_id is required"}, fmt.Errorf("suggestion_id is required")
+	}
+	if h.cache == nil {
+		return &Response{Error: "feedback requires the cache to be enabled"}, fmt.Errorf("cache disabled")
+	}
+
+	suggestion, err := h.cache.GetSuggestion(req.SuggestionID)
+	if err != nil {
+		err = fmt.Errorf("unknown suggestion %d: %w", req.SuggestionID, err)
+		return &Response{Error: err.Error()}, err
+	}
+
+	// The shell reports the candidate it showed last, which may be an
+	// alternative to the stored command
+	command := suggestion.Command
+	if req.Suggestion != "" {
+		command = req.Suggestion
+	}
+
+	outcome := classifyOutcome(command, req.Executed)
+	if err := h.cache.RecordFeedback(suggestion.ID, command, req.Executed, outcome); err != nil {
+		return &Response{Error: err.Error()}, err
+	}
+
+	return &Response{Result: &FeedbackResult{Outcome: outcome}}, nil
+}
+
+// recordSuggestion stores a served suggestion so feedback can refer to it
+func (h *handler) recordSuggestion(method string, resp *Response) {
+	if h.cache == nil || resp == nil {
+		return
+	}
+	result, ok := resp.Result.(*PredictResult)
+	if !ok || result.Command == "" {
+		return
+	}
+
+	// Answers not coming from an LLM are attributed to their source
+	provider := result.Provider
+	if provider == "" {
+		provider = result.Source
+	}
+
+	id, err := h.cache.AddSuggestion(&cache.Suggestion{
+		Method:   method,
+		Provider: provider,
+		Model:    result.Model,
+		Command:  result.Command,
+	})
+	if err == nil {
+		result.SuggestionID = id
+	}
+}
+
+// classifyOutcome compares a suggestion with the command line that was
+// executed. Running the same program with other arguments counts as an edit;
+// running something else, or nothing, discards the suggestion.
+func classifyOutcome(suggestion, executed string) string {
+	suggestion = strings.TrimSpace(suggestion)
+	executed = strings.TrimSpace(executed)
+
+	switch {
+	case executed == suggestion:
+		return cache.OutcomeAccepted
+	case executed != "" && firstWord(executed) == firstWord(suggestion):
+		return cache.OutcomeEdited
+	default:
+		return cache.OutcomeDiscarded
+	}
+}
+
+// firstWord returns the program name of a command line
+func firstWord(commandLine string) string {
+	fields := strings.Fields(commandLine)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
@@ -0,0 +1,297 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"llmsh/pkg/cache"
+	"llmsh/pkg/config"
+	shellctx "llmsh/pkg/context"
+	"llmsh/pkg/history"
+	"llmsh/pkg/llm"
+	"llmsh/pkg/tracker"
+)
+
+// handler serves requests using state that outlives a single request, so the
+// daemon can keep the config, cache and LLM client warm between calls
+type handler struct {
+	cfg     *config.Config
+	cache   *cache.Cache
+	client  *llm.Client
+	offline *history.Model
+}
+
+// newHandler creates a handler from the loaded configuration
+func newHandler(cfg *config.Config) *handler {
+	h := &handler{
+		cfg:    cfg,
+		client: llm.NewClient(cfg.LLM),
+	}
+
+	// Budgets are checked against tracked usage before every provider call
+	tracker.SetDBPath(cfg.Tracking.DBPath)
+	tracker.SetPricing(cfg.Price)
+	if cfg.Tracking.Enabled && cfg.Tracking.Budget.Enabled() {
+		budget := cfg.Tracking.Budget
+		h.client.SetGuard(func(provider string) error {
+			return tracker.CheckBudget(budget, provider)
+		})
+	}
+
+	// A cache that fails to open only disables caching
+	if cfg.Cache.Enabled {
+		if cacheDB, err := openCache(cfg); err == nil {
+			h.cache = cacheDB
+		}
+	}
+
+	// Likewise for the offline predictor, whose model history providers
+	// share
+	history.SetDBPath(cfg.Offline.DBPath)
+	if cfg.Offline.Enabled {
+		if model, err := history.Shared(); err == nil {
+			h.offline = model
+		}
+	}
+
+	return h
+}
+
+// Close releases resources held by the handler
+func (h *handler) Close() error {
+	var errs []error
+	if h.cache != nil {
+		errs = append(errs, h.cache.Close())
+	}
+	errs = append(errs, history.Close())
+	errs = append(errs, tracker.Close())
+	return errors.Join(errs...)
+}
+
+// learn feeds the history window of a request to the offline predictor
+func (h *handler) learn(filteredHistory []string, req *Request) {
+	if h.offline != nil {
+		h.offline.Learn(filteredHistory, history.Window{Session: req.Session, End: req.HistoryEnd}, req.CWD, req.GitBranch)
+	}
+}
+
+// offlinePredict answers from the offline predictor when its best guess is
+// at least minConfidence, or returns nil
+func (h *handler) offlinePredict(filteredHistory []string, req *Request, n int, minConfidence float64) *PredictResult {
+	if h.offline == nil {
+		return nil
+	}
+
+	predictions, err := h.offline.Predict(filteredHistory, req.CWD, req.GitBranch, req.Prefix, n)
+	if err != nil || len(predictions) == 0 || predictions[0].Confidence < minConfidence {
+		return nil
+	}
+
+	result := &PredictResult{
+		Command:    predictions[0].Command,
+		Confidence: predictions[0].Confidence,
+		Source:     SourceHistory,
+	}
+	if n > 1 {
+		for _, prediction := range predictions {
+			result.Candidates = append(result.Candidates, Candidate{
+				Command:    prediction.Command,
+				Confidence: prediction.Confidence,
+			})
+		}
+	}
+	return result
+}
+
+// promptHistory shortens the overly long commands of a history window before
+// it goes into a prompt
+func (h *handler) promptHistory(filteredHistory []string) []string {
+	return shellctx.TruncateLines(filteredHistory, h.cfg.Prediction.MaxLineTokens)
+}
+
+// cacheKey derives the cache key of a request to a method from its prefix
+// or description and its context
+func (h *handler) cacheKey(method, input string, filteredHistory []string, req *Request) string {
+	return cache.Key(cache.KeyInput{
+		Method:    method,
+		Input:     input,
+		History:   filteredHistory,
+		CWD:       req.CWD,
+		GitBranch: req.GitBranch,
+		OSInfo:    req.OSInfo,
+	}, cacheKeyOptions(h.cfg, method))
+}
+
+// cachedResult answers from the cache when answers of the method are cached
+// and one is stored under the key, or returns nil
+func (h *handler) cachedResult(method, key string) *PredictResult {
+	if h.cache == nil || !h.cfg.Cache.MethodEnabled(method) {
+		return nil
+	}
+
+	cached := h.cache.Get(method, key)
+	if cached == nil {
+		return nil
+	}
+	return &PredictResult{
+		Command: cached.Command,
+		Cached:  true,
+		Source:  SourceCache,
+	}
+}
+
+// cacheResult stores the top command answered to a request under the key,
+// along with the embedding of the request input if any
+func (h *handler) cacheResult(method, key string, req *Request, command string, embedding *llm.Embedding) {
+	if h.cache == nil || !h.cfg.Cache.MethodEnabled(method) {
+		return
+	}
+
+	entry := &cache.CacheEntry{
+		ContextHash: key,
+		Method:      method,
+		Command:     command,
+		CWD:         req.CWD,
+		GitBranch:   req.GitBranch,
+	}
+	if embedding != nil {
+		entry.Embedding = embedding.Vector
+		entry.EmbeddingModel = h.cfg.Cache.Semantic.Model
+	}
+	h.cache.Set(entry)
+}
+
+// budgetFallback answers from the cache once the budget is exhausted, since
+// a cached command beats none even when alternatives were asked for
+func (h *handler) budgetFallback(err error, method, key string) *Response {
+	if !errors.Is(err, tracker.ErrBudgetExceeded) {
+		return nil
+	}
+	if cached := h.cachedResult(method, key); cached != nil {
+		return &Response{Result: cached, ErrorCode: ErrorCodeBudgetExceeded}
+	}
+	return nil
+}
+
+// handle dispatches a request to the method it names. A nil response with a
+// non-nil error means the call failed and nothing should be written.
+func (h *handler) handle(ctx context.Context, req *Request) (*Response, error) {
+	var resp *Response
+	var err error
+
+	// Methods only see their history window, however much the client sent
+	req.History = shellctx.LastCommands(req.History, h.cfg.Prediction.MethodHistoryLength(req.Method))
+
+	switch req.Method {
+	case "predict":
+		resp, err = h.predict(ctx, req)
+	case "complete":
+		resp, err = h.complete(ctx, req)
+	case "nl2cmd":
+		resp, err = h.nl2cmd(ctx, req)
+	case "explain":
+		return h.explain(ctx, req)
+	case "feedback":
+		return h.feedback(ctx, req)
+	default:
+		err := fmt.Errorf("unknown method: %q", req.Method)
+		return &Response{Error: err.Error()}, err
+	}
+
+	if err == nil {
+		resp, err = h.checkSafety(resp)
+	}
+	// Alternatives are fetched for a suggestion already shown, whose ID
+	// the shell keeps reporting feedback with
+	if candidateCount(req) == 1 {
+		h.recordSuggestion(req.Method, resp)
+	}
+	return resp, err
+}
+
+// offlineFallback answers from the offline predictor after the LLM failed,
+// unless the user canceled the request
+func (h *handler) offlineFallback(err error, filteredHistory []string, req *Request, n int) *PredictResult {
+	if errors.Is(err, llm.ErrCanceled) {
+		return nil
+	}
+	return h.offlinePredict(filteredHistory, req, n, 0)
+}
+
+// llmErrorResponse turns an LLM error into a structured response for
+// timeouts, cancellations and exhausted budgets; other LLM errors fail
+// silently
+func llmErrorResponse(err error) *Response {
+	code := errorCode(err)
+	if code == "" {
+		return nil
+	}
+	return &Response{Error: err.Error(), ErrorCode: code}
+}
+
+// errorCode classifies an LLM error, or returns "" for other errors
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, llm.ErrTimeout):
+		return ErrorCodeTimeout
+	case errors.Is(err, llm.ErrCanceled):
+		return ErrorCodeCanceled
+	case errors.Is(err, tracker.ErrBudgetExceeded):
+		return ErrorCodeBudgetExceeded
+	default:
+		return ""
+	}
+}
+
+// runOneShot reads a single request in the selected input mode, serves it
+// and writes the response to stdout in the selected output format. A running
+// daemon serves the request when there is one. With stream, the answer is
+// streamed ahead of the response whatever the request says.
+func runOneShot(ctx context.Context, method string, stream bool) error {
+	if !validOutput(outputMode) {
+		err := fmt.Errorf("unknown output format %q: use json, text, sh or fish", outputMode)
+		writeOutput(os.Stdout, OutputJSON, method, &Response{Error: err.Error()})
+		return err
+	}
+
+	// Read the request
+	req, err := readInput()
+	if err != nil {
+		writeOutput(os.Stdout, outputMode, method, &Response{Error: err.Error()})
+		return err
+	}
+
+	// The subcommand decides the method, whatever the request says
+	req.Method = method
+	if requestCandidates > 0 {
+		req.Candidates = requestCandidates
+	}
+	if stream {
+		req.Stream = true
+	}
+	if req.Stream {
+		req.emit = func(chunk *StreamChunk) {
+			writeChunk(os.Stdout, outputMode, chunk)
+		}
+	}
+
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		writeOutput(os.Stdout, outputMode, method, &Response{Error: fmt.Sprintf("load config: %v", err)})
+		return err
+	}
+
+	if resp, ok := forwardRequest(ctx, cfg, req); ok {
+		return writeOutput(os.Stdout, outputMode, method, resp)
+	}
+
+	h := newHandler(cfg)
+	defer h.Close()
+
+	resp, err := h.handle(ctx, req)
+	writeOutput(os.Stdout, outputMode, method, resp)
+	return err
+}
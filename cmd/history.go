@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"llmsh/pkg/config"
+	"llmsh/pkg/history"
+
+	"github.com/spf13/cobra"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Manage the offline history model",
+	Long: `Manage the local n-gram model that predicts commands from shell history
+without calling an LLM.`,
+}
+
+var historyImportCmd = &cobra.Command{
+	Use:   "import [file]",
+	Short: "Train the offline model from a shell history file",
+	Long: `Read a zsh, bash or fish history file and count its command transitions in
+the offline model. Without a file, $HISTFILE or the default history file of
+$SHELL is used.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runHistoryImport,
+}
+
+func init() {
+	historyCmd.AddCommand(historyImportCmd)
+}
+
+func runHistoryImport(cmd *cobra.Command, args []string) error {
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	path := history.DefaultHistoryFile()
+	if len(args) > 0 {
+		path = args[0]
+	}
+	if path == "" {
+		return fmt.Errorf("no history file found, pass one explicitly")
+	}
+
+	commands, err := history.ReadHistoryFile(path)
+	if err != nil {
+		return fmt.Errorf("read history: %w", err)
+	}
+
+	model, err := history.Open(cfg.Offline.DBPath)
+	if err != nil {
+		return fmt.Errorf("open history model: %w", err)
+	}
+	defer model.Close()
+
+	count, err := model.Import(commands)
+	if err != nil {
+		return fmt.Errorf("import history: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Imported %d commands from %s\n", count, path)
+	return nil
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"llmsh/pkg/config"
+	"llmsh/pkg/shell"
+
+	"github.com/spf13/cobra"
+)
+
+var initCmd = &cobra.Command{
+	Use:   "init SHELL",
+	Short: "Print the integration script of a shell",
+	Long: fmt.Sprintf(`Print the script that binds llmsh widgets in a shell, for the shell to
+evaluate at startup. Supported shells: %s.
+
+The script is rendered from ~/.llmsh/config.yaml: the binary it calls
+(zsh.binary), the daemon socket zsh talks to (daemon.socket_path), the
+history it sends (prediction.history_length, or the longest
+prediction.methods.<method>.history_length), the keybindings
+(zsh.keybindings, in bindkey notation for every shell), and how long zsh
+waits for the daemon (llm.timeouts). New shells pick up changes to the
+config.
+
+For zsh, add to ~/.zshrc:
+
+  eval "$(llmsh init zsh)"
+
+For bash, add to ~/.bashrc:
+
+  eval "$(llmsh init bash)"
+
+For fish, add to ~/.config/fish/config.fish:
+
+  llmsh init fish | source`, strings.Join(shell.Shells(), ", ")),
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: shell.Shells(),
+	RunE:      runInit,
+}
+
+func runInit(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		// The widgets still work once the config is fixed, so render with
+		// the defaults rather than leave the shell without them
+		fmt.Fprintf(os.Stderr, "llmsh: %v; using default settings\n", err)
+		cfg = &config.Config{}
+	}
+
+	script, err := shell.Script(args[0], shellSettings(cfg))
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(script)
+	return nil
+}
+
+// shellSettings returns the settings the integration scripts are rendered
+// with, filling in defaults for what the config leaves unset
+func shellSettings(cfg *config.Config) shell.Settings {
+	settings := shell.Settings{
+		Binary:        cfg.ZSH.Binary,
+		SocketPath:    cfg.Daemon.SocketPath,
+		HistoryLength: cfg.Prediction.ShellHistoryLength(),
+		Keybindings:   cfg.ZSH.WidgetKeybindings(),
+		Timeouts:      make(map[string]time.Duration),
+	}
+
+	// zsh waits as long for the daemon as one-shot commands do
+	for _, method := range []string{"predict", "complete", "nl2cmd", "explain"} {
+		if timeout := cfg.LLM.Timeout(method); timeout > 0 {
+			settings.Timeouts[method] = timeout + forwardGrace
+		}
+	}
+
+	if settings.Binary == "" {
+		if executable, err := os.Executable(); err == nil {
+			settings.Binary = executable
+		} else {
+			home, _ := os.UserHomeDir()
+			settings.Binary = filepath.Join(home, ".local", "bin", "llmsh")
+		}
+	}
+	if settings.SocketPath == "" {
+		settings.SocketPath = config.DefaultSocketPath()
+	}
+	if settings.HistoryLength <= 0 {
+		settings.HistoryLength = config.DefaultHistoryLength
+	}
+	return settings
+}
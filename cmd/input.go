@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	shellctx "llmsh/pkg/context"
+
+	"github.com/spf13/cobra"
+)
+
+// Input modes of the one-shot commands, selected with --input
+const (
+	// InputJSON reads the request as JSON from stdin
+	InputJSON = "json"
+	// InputArgs reads the request fields from flags
+	InputArgs = "args"
+	// InputEnv reads the request fields from LLMSH_* environment variables,
+	// which unlike arguments are not visible to other users
+	InputEnv = "env"
+)
+
+var (
+	inputMode string
+	// argsRequest holds the request fields given as flags
+	argsRequest Request
+	// requestCandidates overrides the number of candidates in every input
+	// mode
+	requestCandidates int
+)
+
+// addRequestFlags registers the flags selecting how a one-shot command reads
+// its request and writes its response, and the flags of the request fields
+// it takes besides the shell context: prefix, description, command,
+// candidates or feedback
+func addRequestFlags(cmd *cobra.Command, fields ...string) {
+	flags := cmd.Flags()
+	flags.StringVar(&inputMode, "input", InputJSON, "Read the request as JSON from stdin (json), from flags (args) or from LLMSH_* variables (env)")
+	flags.StringVar(&outputMode, "output", OutputJSON, "Write the response as JSON (json), plain text (text), or variable assignments for zsh and bash (sh) or fish (fish)")
+	flags.StringArrayVar(&argsRequest.History, "history", nil, "Command of the recent history, oldest first; repeatable (args input)")
+	flags.StringVar(&argsRequest.CWD, "cwd", "", "Working directory (args input; default: the current directory)")
+	flags.StringVar(&argsRequest.Session, "session", "", "ID of the shell session (args input)")
+	flags.Int64Var(&argsRequest.HistoryEnd, "history-end", 0, "History event number of the last --history command (args input)")
+
+	for _, field := range fields {
+		switch field {
+		case "prefix":
+			flags.StringVar(&argsRequest.Prefix, "prefix", "", "Partial command to complete (args input)")
+		case "description":
+			flags.StringVar(&argsRequest.Description, "description", "", "Natural language description of the command (args input)")
+		case "command":
+			flags.StringVar(&argsRequest.Command, "command", "", "Command line to explain (args input)")
+		case "candidates":
+			flags.IntVar(&requestCandidates, "candidates", 0, "Number of ranked alternatives to return, in any input mode")
+		case "feedback":
+			flags.Int64Var(&argsRequest.SuggestionID, "suggestion-id", 0, "ID of the suggestion (args input)")
+			flags.StringVar(&argsRequest.Suggestion, "suggestion", "", "Command the shell showed last (args input)")
+			flags.StringVar(&argsRequest.Executed, "executed", "", "Command line the user executed, empty if discarded (args input)")
+		}
+	}
+}
+
+// readInput reads the request of a one-shot command in the selected input
+// mode. Requests not read as JSON get the shell context the shell would
+// have sent, gathered from the process.
+func readInput() (*Request, error) {
+	var req *Request
+	switch inputMode {
+	case InputJSON:
+		return readRequest()
+	case InputArgs:
+		args := argsRequest
+		req = &args
+	case InputEnv:
+		var err error
+		if req, err = envRequest(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown input mode %q: use json, args or env", inputMode)
+	}
+
+	if req.CWD == "" {
+		req.CWD, _ = os.Getwd()
+	}
+	addShellContext(req)
+	return req, nil
+}
+
+// addShellContext adds the git branch of the working directory and the OS
+// to a request that lacks them
+func addShellContext(req *Request) {
+	if req.GitBranch == "" && req.CWD != "" {
+		req.GitBranch = shellctx.GitBranch(req.CWD)
+	}
+	if req.OSInfo == "" {
+		req.OSInfo = shellctx.OSName()
+	}
+}
+
+// envRequest reads the request fields from the environment. The history,
+// oldest first, is read from the file named by LLMSH_HISTORY_FILE, or stdin
+// when it is "-", with commands separated by NUL bytes so they can span
+// several lines. Otherwise LLMSH_HISTORY holds one command per line, as
+// printed by fc -ln.
+func envRequest() (*Request, error) {
+	req := &Request{
+		CWD:         os.Getenv("LLMSH_CWD"),
+		Prefix:      os.Getenv("LLMSH_PREFIX"),
+		Description: os.Getenv("LLMSH_DESCRIPTION"),
+		Command:     os.Getenv("LLMSH_COMMAND"),
+		Suggestion:  os.Getenv("LLMSH_SUGGESTION"),
+		Executed:    os.Getenv("LLMSH_EXECUTED"),
+	}
+	req.SuggestionID, _ = strconv.ParseInt(os.Getenv("LLMSH_SUGGESTION_ID"), 10, 64)
+	req.Session = os.Getenv("LLMSH_SESSION")
+	req.HistoryEnd, _ = strconv.ParseInt(os.Getenv("LLMSH_HISTORY_END"), 10, 64)
+
+	history, separator := os.Getenv("LLMSH_HISTORY"), "\n"
+	if path := os.Getenv("LLMSH_HISTORY_FILE"); path != "" {
+		var data []byte
+		var err error
+		if path == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read history: %w", err)
+		}
+		history, separator = string(data), "\x00"
+	}
+
+	for _, command := range strings.Split(history, separator) {
+		if command = strings.TrimSpace(command); command != "" {
+			req.History = append(req.History, command)
+		}
+	}
+	return req, nil
+}
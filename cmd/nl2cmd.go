@@ -1,86 +1,217 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
-	"llmsh/pkg/config"
+	shellctx "llmsh/pkg/context"
 	"llmsh/pkg/llm"
 	"llmsh/pkg/tracker"
 
 	"github.com/spf13/cobra"
 )
 
+var nl2cmdStream bool
+
+// similarBudgetShare is the share of the nl2cmd deadline a semantic cache
+// lookup may take: it only pays off when it is quick next to generating the
+// command, which the user waits for once it misses
+const similarBudgetShare = 20
+
 var nl2cmdCmd = &cobra.Command{
 	Use:   "nl2cmd",
 	Short: "Convert natural language to shell command",
-	Long:  `Reads a natural language description from stdin and generates a shell command.`,
-	RunE:  runNL2Cmd,
+	Long: `Reads a natural language description from stdin, or from flags or the
+environment with --input, and generates a shell command.
+
+With --stream, the command is written as it is generated: each line of output
+is a JSON object whose "partial" field holds the command so far, and the last
+line is the usual response. With --output sh or fish, the partial command is
+assigned to llmsh_partial instead.`,
+	RunE: runNL2Cmd,
+}
+
+func init() {
+	nl2cmdCmd.Flags().BoolVar(&nl2cmdStream, "stream", false, "Stream the command while it is generated, ahead of the response")
+	addRequestFlags(nl2cmdCmd, "description", "candidates")
 }
 
 func runNL2Cmd(cmd *cobra.Command, args []string) error {
-	// Read request from stdin
-	req, err := readRequest()
-	if err != nil {
-		writeError(err.Error())
-		return err
-	}
+	return runOneShot(cmd.Context(), "nl2cmd", nl2cmdStream)
+}
 
+// nl2cmd serves a natural language to command request
+func (h *handler) nl2cmd(ctx context.Context, req *Request) (*Response, error) {
 	// Validate description
 	if req.Description == "" {
-		writeError("description is required")
-		return fmt.Errorf("description is required")
+		return &Response{Error: "description is required"}, fmt.Errorf("description is required")
 	}
 
-	// Load configuration
-	cfg, err := config.Load()
-	if err != nil {
-		writeError(fmt.Sprintf("load config: %v", err))
-		return err
+	// The history window still teaches the offline predictor
+	filteredHistory := shellctx.FilterSensitive(req.History)
+	h.learn(filteredHistory, req)
+
+	// Check cache if enabled; asking for alternatives bypasses it since only
+	// the top command is cached
+	cacheKey := h.cacheKey("nl2cmd", req.Description, filteredHistory, req)
+	n := candidateCount(req)
+	var embedding *llm.Embedding
+	if n == 1 {
+		if cached := h.cachedResult("nl2cmd", cacheKey); cached != nil {
+			return &Response{Result: cached}, nil
+		}
+
+		// Reuse the answer to a description of similar meaning
+		var similar *PredictResult
+		if similar, embedding = h.similarResult(ctx, req); similar != nil {
+			return &Response{Result: similar}, nil
+		}
 	}
 
 	// Build prompt
-	prompt := buildNL2CmdPrompt(req.Description, req.CWD, req.History, req.OSInfo)
-
-	// Call LLM
-	client := llm.NewClient(cfg.LLM)
-	result, err := client.Generate(prompt)
+	prompt := buildNL2CmdPrompt(req.Description, req.CWD, h.promptHistory(filteredHistory), req.OSInfo)
+
+	// Call LLM, streaming the command if asked to; once a partial command is
+	// held back by the safety rules, so is the rest of the stream
+	llmReq := &llm.Request{System: nl2cmdInstructions, Prompt: prompt, N: n}
+	var result *llm.Result
+	var err error
+	if req.Stream && req.emit != nil {
+		var answer strings.Builder
+		var last string
+		held := false
+		result, err = h.client.GenerateStream(ctx, llmReq, func(delta string) {
+			answer.WriteString(delta)
+			if held {
+				return
+			}
+			partial := partialCommand(answer.String())
+			if held = h.holdsPartial(partial); held {
+				return
+			}
+			if partial != last {
+				req.emit(&StreamChunk{Partial: partial})
+				last = partial
+			}
+		})
+	} else {
+		result, err = h.client.Generate(ctx, llmReq)
+	}
 	if err != nil {
-		// Fail silently for LLM errors
-		return err
+		// Over budget, answer from the cache
+		if resp := h.budgetFallback(err, "nl2cmd", cacheKey); resp != nil {
+			return resp, nil
+		}
+		// Fail silently for LLM errors other than timeouts and cancellation
+		return llmErrorResponse(err), err
 	}
 
+	nl2cmdResult := newPredictResult(result, n)
+
+	// Save to cache
+	h.cacheResult("nl2cmd", cacheKey, req, nl2cmdResult.Command, embedding)
+
 	// Record token usage
-	if cfg.Tracking.Enabled {
+	if h.cfg.Tracking.Enabled {
 		tracker.RecordUsage(&tracker.Record{
-			Method:       "nl2cmd",
-			Provider:     cfg.LLM.DefaultProvider,
-			Model:        result.Model,
-			InputTokens:  result.Usage.InputTokens,
-			OutputTokens: result.Usage.OutputTokens,
+			Method:              "nl2cmd",
+			Provider:            result.Provider,
+			Model:               result.Model,
+			InputTokens:         result.Usage.InputTokens,
+			OutputTokens:        result.Usage.OutputTokens,
+			CacheCreationTokens: result.Usage.CacheCreationTokens,
+			CacheReadTokens:     result.Usage.CacheReadTokens,
 		})
 	}
 
-	// Write response
-	writeResponse(&Response{
-		Result: &PredictResult{
-			Command: result.Command,
-			Cached:  false,
-		},
+	return &Response{
+		Result: nl2cmdResult,
 		Tokens: &TokenUsage{
-			InputTokens:  result.Usage.InputTokens,
-			OutputTokens: result.Usage.OutputTokens,
+			InputTokens:         result.Usage.InputTokens,
+			OutputTokens:        result.Usage.OutputTokens,
+			CacheCreationTokens: result.Usage.CacheCreationTokens,
+			CacheReadTokens:     result.Usage.CacheReadTokens,
 		},
-	})
+	}, nil
+}
 
-	return nil
+// partialCommand extracts the command from a partial answer, dropping an
+// opening markdown code fence and the start of a closing one
+func partialCommand(answer string) string {
+	answer = strings.TrimSpace(answer)
+	if strings.HasPrefix(answer, "```") {
+		newline := strings.IndexByte(answer, '\n')
+		if newline < 0 {
+			return ""
+		}
+		answer = answer[newline+1:]
+	}
+	return strings.TrimSpace(strings.TrimRight(answer, "`"))
+}
+
+// similarResult answers from the cached command generated for the most
+// similar description when semantic caching is on, with the similarity as
+// confidence. It also returns the embedding of the description to cache
+// along with a new answer, or nil when it is unavailable.
+func (h *handler) similarResult(ctx context.Context, req *Request) (*PredictResult, *llm.Embedding) {
+	semantic := h.cfg.Cache.Semantic
+	if h.cache == nil || !semantic.Enabled || !h.cfg.Cache.MethodEnabled("nl2cmd") {
+		return nil, nil
+	}
+
+	if timeout := h.cfg.LLM.Timeout("nl2cmd"); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout/similarBudgetShare)
+		defer cancel()
+	}
+
+	// Without an embedding, fall back to exact lookups only
+	embedding, err := h.client.Embed(ctx, semantic.Provider, semantic.Model, req.Description)
+	if err != nil {
+		return nil, nil
+	}
+
+	// Record token usage
+	if h.cfg.Tracking.Enabled {
+		tracker.RecordUsage(&tracker.Record{
+			Method:      "embed",
+			Provider:    embedding.Provider,
+			Model:       embedding.Model,
+			InputTokens: embedding.Usage.InputTokens,
+		})
+	}
+
+	entry, similarity, err := h.cache.Similar("nl2cmd", semantic.Model, embedding.Vector, semantic.Threshold)
+	if err != nil || entry == nil {
+		return nil, embedding
+	}
+	return &PredictResult{
+		Command:    entry.Command,
+		Confidence: similarity,
+		Cached:     true,
+		Source:     SourceCache,
+	}, embedding
 }
 
+// nl2cmdInstructions are the system instructions of command generation
+// prompts, asking for a single command carrying out the description
+const nl2cmdInstructions = `You are a shell command generator.
+
+Task: Convert natural language description to a shell command.
+Generate a safe, practical shell command that accomplishes the task.
+Rules:
+- Return ONLY the command, no explanation
+- Ensure the command is safe (no destructive operations without confirmation)
+- Use common Unix/Linux tools
+- Be concise and practical
+- Do not include markdown code blocks`
+
+// buildNL2CmdPrompt builds the part of a command generation prompt that
+// changes between requests
 func buildNL2CmdPrompt(description, cwd string, history []string, osInfo string) string {
 	var sb strings.Builder
 
-	sb.WriteString("You are a shell command generator.\n\n")
-	sb.WriteString("Task: Convert natural language description to a shell command.\n")
 	sb.WriteString("Context:\n")
 	if osInfo != "" {
 		sb.WriteString(fmt.Sprintf("- OS: %s\n", osInfo))
@@ -90,24 +221,12 @@ func buildNL2CmdPrompt(description, cwd string, history []string, osInfo string)
 
 	if len(history) > 0 {
 		sb.WriteString("- Recent commands (for context):\n")
-		// Show last 3 commands
-		start := len(history) - 3
-		if start < 0 {
-			start = 0
-		}
-		for i := start; i < len(history); i++ {
-			sb.WriteString(fmt.Sprintf("  %d. %s\n", i-start+1, history[i]))
+		for i, entry := range history {
+			sb.WriteString(fmt.Sprintf("  %d. %s\n", i+1, entry))
 		}
 	}
 
-	sb.WriteString("\nGenerate a safe, practical shell command that accomplishes the task.\n")
-	sb.WriteString("Rules:\n")
-	sb.WriteString("- Return ONLY the command, no explanation\n")
-	sb.WriteString("- Ensure the command is safe (no destructive operations without confirmation)\n")
-	sb.WriteString("- Use common Unix/Linux tools\n")
-	sb.WriteString("- Be concise and practical\n")
-	sb.WriteString("- Do not include markdown code blocks\n\n")
-	sb.WriteString("Command:")
+	sb.WriteString("\nCommand:")
 
 	return sb.String()
 }
@@ -0,0 +1,222 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"llmsh/pkg/shell"
+)
+
+// Output formats of the one-shot commands, selected with --output
+const (
+	// OutputJSON writes the response as a line of JSON
+	OutputJSON = "json"
+	// OutputText writes the command, explanation or outcome alone, and
+	// errors to stderr
+	OutputText = "text"
+	// OutputSh writes typeset statements for zsh and bash to eval, one per
+	// line. Evaluated in a function, they declare local variables.
+	OutputSh = "sh"
+	// OutputFish writes set -g statements for fish to source
+	OutputFish = "fish"
+)
+
+var outputMode string
+
+// validOutput reports whether an output format is known
+func validOutput(format string) bool {
+	switch format {
+	case OutputJSON, OutputText, OutputSh, OutputFish:
+		return true
+	}
+	return false
+}
+
+// outputVar is a shell variable set from a response
+type outputVar struct {
+	name   string
+	values []string
+	// list makes the variable an array even with a single value
+	list bool
+}
+
+// responseVars returns the variables an sh or fish response sets. All of a
+// method's variables are set, empty if need be, so that none survives from a
+// previous response.
+func responseVars(method string, resp *Response) []outputVar {
+	if resp == nil {
+		resp = &Response{}
+	}
+
+	vars := []outputVar{
+		{name: "llmsh_error", values: []string{resp.Error}},
+		{name: "llmsh_error_code", values: []string{resp.ErrorCode}},
+	}
+	scalar := func(name, value string) {
+		vars = append(vars, outputVar{name: name, values: []string{value}})
+	}
+	list := func(name string, values []string) {
+		vars = append(vars, outputVar{name: name, values: values, list: true})
+	}
+
+	switch method {
+	case "explain":
+		result, _ := resp.Result.(*ExplainResult)
+		if result == nil {
+			result = &ExplainResult{}
+		}
+		scalar("llmsh_explanation", formatExplanation(result))
+		scalar("llmsh_risk", result.Risk)
+
+	case "feedback":
+		result, _ := resp.Result.(*FeedbackResult)
+		if result == nil {
+			result = &FeedbackResult{}
+		}
+		scalar("llmsh_outcome", result.Outcome)
+
+	default:
+		result, _ := resp.Result.(*PredictResult)
+		if result == nil {
+			result = &PredictResult{}
+		}
+
+		// The candidates include the command, which is the first of them
+		var candidates, candidateRisks []string
+		addCandidate := func(command, risk, action string) {
+			if command == "" {
+				return
+			}
+			if action == "" {
+				risk = "-"
+			}
+			candidates = append(candidates, command)
+			candidateRisks = append(candidateRisks, risk)
+		}
+		if len(result.Candidates) > 0 {
+			for _, c := range result.Candidates {
+				addCandidate(c.Command, c.Risk, c.Action)
+			}
+		} else {
+			addCandidate(result.Command, result.Risk, result.Action)
+		}
+
+		suggestionID := ""
+		if result.SuggestionID != 0 {
+			suggestionID = strconv.FormatInt(result.SuggestionID, 10)
+		}
+
+		scalar("llmsh_command", result.Command)
+		list("llmsh_candidates", candidates)
+		list("llmsh_candidate_risks", candidateRisks)
+		scalar("llmsh_risk", result.Risk)
+		list("llmsh_risk_reasons", result.RiskReasons)
+		scalar("llmsh_action", result.Action)
+		scalar("llmsh_suggestion_id", suggestionID)
+	}
+	return vars
+}
+
+// writeOutput writes a response in the output format; a nil response, left
+// by a silent failure, only clears the variables of the sh and fish formats
+func writeOutput(w io.Writer, format, method string, resp *Response) error {
+	switch format {
+	case OutputSh, OutputFish:
+		var b strings.Builder
+		for _, v := range responseVars(method, resp) {
+			writeVar(&b, format, v)
+		}
+		_, err := io.WriteString(w, b.String())
+		return err
+
+	case OutputText:
+		if resp == nil {
+			return nil
+		}
+		if resp.Error != "" {
+			fmt.Fprintf(os.Stderr, "llmsh: %s\n", resp.Error)
+		}
+		text := ""
+		switch result := resp.Result.(type) {
+		case *PredictResult:
+			text = result.Command
+		case *ExplainResult:
+			text = formatExplanation(result)
+		case *FeedbackResult:
+			text = result.Outcome
+		}
+		if text == "" {
+			return nil
+		}
+		_, err := fmt.Fprintln(w, text)
+		return err
+
+	default:
+		if resp == nil {
+			return nil
+		}
+		return encodeResponse(w, resp)
+	}
+}
+
+// writeChunk writes a chunk of a streamed answer in the output format. Text
+// output has no partial commands, since it can't take them back.
+func writeChunk(w io.Writer, format string, chunk *StreamChunk) error {
+	switch format {
+	case OutputSh, OutputFish:
+		var b strings.Builder
+		writeVar(&b, format, outputVar{name: "llmsh_partial", values: []string{chunk.Partial}})
+		_, err := io.WriteString(w, b.String())
+		return err
+	case OutputText:
+		return nil
+	default:
+		return encodeChunk(w, chunk)
+	}
+}
+
+// writeVar writes the statement setting a variable in the syntax of the
+// output format
+func writeVar(b *strings.Builder, format string, v outputVar) {
+	if format == OutputFish {
+		b.WriteString("set -g " + v.name)
+		for _, value := range v.values {
+			b.WriteString(" " + shell.QuoteFish(value))
+		}
+		b.WriteString("\n")
+		return
+	}
+
+	quoted := make([]string, len(v.values))
+	for i, value := range v.values {
+		quoted[i] = shell.QuoteSh(value)
+	}
+	if v.list {
+		fmt.Fprintf(b, "typeset -a %s=(%s)\n", v.name, strings.Join(quoted, " "))
+		return
+	}
+	fmt.Fprintf(b, "typeset %s=%s\n", v.name, strings.Join(quoted, " "))
+}
+
+// formatExplanation formats an explanation for display under the prompt
+func formatExplanation(result *ExplainResult) string {
+	if result.Summary == "" {
+		return ""
+	}
+
+	lines := []string{result.Summary, ""}
+	for _, arg := range result.Arguments {
+		lines = append(lines, fmt.Sprintf("  %s  %s", arg.Argument, arg.Explanation))
+	}
+	if len(result.SideEffects) > 0 {
+		lines = append(lines, "", "Side effects:")
+		for _, effect := range result.SideEffects {
+			lines = append(lines, "  - "+effect)
+		}
+	}
+	lines = append(lines, "", "Risk: "+result.Risk)
+	return strings.Join(lines, "\n")
+}
@@ -1,13 +1,11 @@
 package cmd
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	stdcontext "context"
 	"fmt"
+	"sort"
 	"strings"
 
-	"llmsh/pkg/cache"
-	"llmsh/pkg/config"
 	"llmsh/pkg/context"
 	"llmsh/pkg/llm"
 	"llmsh/pkg/tracker"
@@ -20,78 +18,115 @@ type PredictResult struct {
 	Command    string  `json:"command"`
 	Confidence float64 `json:"confidence,omitempty"`
 	Cached     bool    `json:"cached"`
+	// Source tells where the command came from: llm, cache or history
+	Source   string `json:"source,omitempty"`
+	Provider string `json:"provider,omitempty"`
+	Model    string `json:"model,omitempty"`
+	// SuggestionID identifies the suggestion in feedback requests
+	SuggestionID int64 `json:"suggestion_id,omitempty"`
+	// Candidates holds ranked alternatives when more than one was requested
+	Candidates []Candidate `json:"candidates,omitempty"`
+	// Risk rates the command with the local safety rules: low, medium or high
+	Risk string `json:"risk,omitempty"`
+	// RiskReasons describes what the safety rules flagged
+	RiskReasons []string `json:"risk_reasons,omitempty"`
+	// Action asks the shell to warn or to confirm before inserting the
+	// command, when its risk reaches the configured level
+	Action string `json:"action,omitempty"`
 }
 
+// Values of PredictResult.Source
+const (
+	SourceLLM     = "llm"
+	SourceCache   = "cache"
+	SourceHistory = "history"
+)
+
+// Candidate represents one ranked alternative command
+type Candidate struct {
+	Command    string  `json:"command"`
+	Confidence float64 `json:"confidence"`
+	Risk       string  `json:"risk,omitempty"`
+	Action     string  `json:"action,omitempty"`
+}
+
+// maxCandidates caps the number of alternatives a request may ask for
+const maxCandidates = 10
+
 var predictCmd = &cobra.Command{
 	Use:   "predict",
 	Short: "Predict the next command based on context",
-	Long:  `Reads context from stdin as JSON and predicts the next shell command.`,
-	RunE:  runPredict,
+	Long: `Reads context from stdin as JSON, or from flags or the environment with
+--input, and predicts the next shell command.`,
+	RunE: runPredict,
 }
 
-func runPredict(cmd *cobra.Command, args []string) error {
-	// Read request from stdin
-	req, err := readRequest()
-	if err != nil {
-		writeError(err.Error())
-		return err
-	}
+func init() {
+	addRequestFlags(predictCmd, "candidates")
+}
 
-	// Load configuration
-	cfg, err := config.Load()
-	if err != nil {
-		writeError(fmt.Sprintf("load config: %v", err))
-		return err
-	}
+func runPredict(cmd *cobra.Command, args []string) error {
+	return runOneShot(cmd.Context(), "predict", false)
+}
 
+// predict serves a next-command prediction request
+func (h *handler) predict(ctx stdcontext.Context, req *Request) (*Response, error) {
 	// Filter sensitive information
 	filteredHistory := context.FilterSensitive(req.History)
+	h.learn(filteredHistory, req)
 
 	// Generate cache key
-	cacheKey := generateCacheKey(filteredHistory, req.CWD, req.GitBranch)
-
-	// Check cache if enabled
-	if cfg.Cache.Enabled {
-		cacheDB, err := cache.Open(cfg.Cache.DBPath)
-		if err == nil {
-			defer cacheDB.Close()
-
-			if cached := cacheDB.Get(cacheKey); cached != nil {
-				writeResponse(&Response{
-					Result: &PredictResult{
-						Command: cached.Command,
-						Cached:  true,
-					},
-				})
-				return nil
-			}
+	cacheKey := h.cacheKey("predict", "", filteredHistory, req)
+
+	// Check cache if enabled; asking for alternatives bypasses it since only
+	// the top command is cached
+	n := candidateCount(req)
+	if n == 1 {
+		if cached := h.cachedResult("predict", cacheKey); cached != nil {
+			return &Response{Result: cached}, nil
+		}
+	}
+
+	// Answer instantly from the offline predictor when it is confident
+	if h.cfg.Offline.FirstPass {
+		if offline := h.offlinePredict(filteredHistory, req, n, h.cfg.Offline.MinConfidence); offline != nil {
+			return &Response{Result: offline}, nil
 		}
 	}
 
 	// Build prompt
-	prompt := buildPredictPrompt(filteredHistory, req.CWD, req.GitBranch, req.OSInfo)
+	prompt := buildPredictPrompt(h.promptHistory(filteredHistory), req.CWD, req.GitBranch, req.OSInfo)
 
 	// Call LLM
-	client := llm.NewClient(cfg.LLM)
-	result, err := client.Predict(prompt)
+	result, err := h.client.Predict(ctx, &llm.Request{
+		System: predictInstructions,
+		Prompt: prompt,
+		N:      n,
+		Shell:  shellContext(filteredHistory, req),
+	})
 	if err != nil {
-		// Fail silently for LLM errors
-		return err
+		// Over budget, answer from the cache
+		if resp := h.budgetFallback(err, "predict", cacheKey); resp != nil {
+			return resp, nil
+		}
+		// Fall back to the offline predictor, e.g. when the network is down
+		if offline := h.offlineFallback(err, filteredHistory, req, n); offline != nil {
+			return &Response{Result: offline, ErrorCode: errorCode(err)}, nil
+		}
+		// Fail silently for LLM errors other than timeouts and cancellation
+		return llmErrorResponse(err), err
 	}
 
+	predictResult := newPredictResult(result, n)
+
 	// Save to cache
-	if cfg.Cache.Enabled {
-		if cacheDB, err := cache.Open(cfg.Cache.DBPath); err == nil {
-			defer cacheDB.Close()
-			cacheDB.Set(cacheKey, result.Command)
-		}
-	}
+	h.cacheResult("predict", cacheKey, req, predictResult.Command, nil)
 
 	// Record token usage
-	if cfg.Tracking.Enabled {
+	if h.cfg.Tracking.Enabled {
 		tracker.RecordUsage(&tracker.Record{
 			Method:              "predict",
-			Provider:            cfg.LLM.DefaultProvider,
+			Provider:            result.Provider,
 			Model:               result.Model,
 			InputTokens:         result.Usage.InputTokens,
 			OutputTokens:        result.Usage.OutputTokens,
@@ -100,37 +135,114 @@ func runPredict(cmd *cobra.Command, args []string) error {
 		})
 	}
 
-	// Write response
-	writeResponse(&Response{
-		Result: &PredictResult{
-			Command: result.Command,
-			Cached:  false,
-		},
+	return &Response{
+		Result: predictResult,
 		Tokens: &TokenUsage{
 			InputTokens:         result.Usage.InputTokens,
 			OutputTokens:        result.Usage.OutputTokens,
 			CacheCreationTokens: result.Usage.CacheCreationTokens,
 			CacheReadTokens:     result.Usage.CacheReadTokens,
 		},
-	})
+	}, nil
+}
+
+// candidateCount returns the number of alternatives a request asks for
+func candidateCount(req *Request) int {
+	return max(1, min(req.Candidates, maxCandidates))
+}
+
+// newPredictResult builds the result of an LLM call. When several commands
+// were sampled, they are ranked and their sampling frequency is reported as
+// the confidence.
+func newPredictResult(result *llm.Result, n int) *PredictResult {
+	predictResult := &PredictResult{
+		Command:  result.Command,
+		Source:   SourceLLM,
+		Provider: result.Provider,
+		Model:    result.Model,
+	}
+	if len(result.Choices) <= 1 {
+		return predictResult
+	}
+
+	ranked := rankCandidates(result.Choices)
+	if len(ranked) == 0 {
+		// Every sampled answer was blank
+		return predictResult
+	}
+	if len(result.Confidences) == len(result.Choices) {
+		// The provider ranked its own answers
+		ranked = make([]Candidate, len(result.Choices))
+		for i, choice := range result.Choices {
+			ranked[i] = Candidate{Command: choice, Confidence: result.Confidences[i]}
+		}
+	}
+	predictResult.Command = ranked[0].Command
+	predictResult.Confidence = ranked[0].Confidence
+	if n > 1 {
+		predictResult.Candidates = ranked[:min(n, len(ranked))]
+	}
+	return predictResult
+}
 
-	return nil
+// shellContext builds the structured context handed to providers that do
+// not work from prompts
+func shellContext(filteredHistory []string, req *Request) *llm.ShellContext {
+	return &llm.ShellContext{
+		History:   filteredHistory,
+		CWD:       req.CWD,
+		GitBranch: req.GitBranch,
+		Prefix:    req.Prefix,
+	}
 }
 
-func generateCacheKey(history []string, cwd, gitBranch string) string {
-	h := sha256.New()
-	for _, cmd := range history {
-		h.Write([]byte(cmd))
+// rankCandidates deduplicates sampled commands and orders them by how often
+// they were sampled, keeping the sampling order for ties. Blank answers are
+// left out and do not count against the others.
+func rankCandidates(choices []string) []Candidate {
+	counts := make(map[string]int)
+	var order []string
+	sampled := 0
+	for _, choice := range choices {
+		if choice == "" {
+			continue
+		}
+		if counts[choice] == 0 {
+			order = append(order, choice)
+		}
+		counts[choice]++
+		sampled++
+	}
+
+	candidates := make([]Candidate, 0, len(order))
+	for _, command := range order {
+		candidates = append(candidates, Candidate{
+			Command:    command,
+			Confidence: float64(counts[command]) / float64(sampled),
+		})
 	}
-	h.Write([]byte(cwd))
-	h.Write([]byte(gitBranch))
-	return hex.EncodeToString(h.Sum(nil))[:32]
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Confidence > candidates[j].Confidence
+	})
+	return candidates
 }
 
+// predictInstructions are the system instructions of prediction prompts,
+// asking for the command most likely to follow the history
+const predictInstructions = `You are a shell command prediction assistant.
+
+Predict the next most likely command the user will execute, given the context of their shell session.
+Rules:
+- Return ONLY the command, no explanation
+- Consider the workflow pattern
+- Be concise and practical
+- Do not include markdown code blocks`
+
+// buildPredictPrompt builds the part of a prediction prompt that changes
+// between requests
 func buildPredictPrompt(history []string, cwd, gitBranch, osInfo string) string {
 	var sb strings.Builder
 
-	sb.WriteString("You are a shell command prediction assistant.\n\n")
 	sb.WriteString("Context:\n")
 	if osInfo != "" {
 		sb.WriteString(fmt.Sprintf("- OS: %s\n", osInfo))
@@ -142,23 +254,13 @@ func buildPredictPrompt(history []string, cwd, gitBranch, osInfo string) string
 
 	if len(history) > 0 {
 		sb.WriteString("- Recent commands:\n")
-		// Show last 10 commands in reverse order (most recent first)
-		start := len(history) - 10
-		if start < 0 {
-			start = 0
-		}
-		for i := len(history) - 1; i >= start; i-- {
+		// Most recent first
+		for i := len(history) - 1; i >= 0; i-- {
 			sb.WriteString(fmt.Sprintf("  %d. %s\n", len(history)-i, history[i]))
 		}
 	}
 
-	sb.WriteString("\nPredict the next most likely command the user will execute.\n")
-	sb.WriteString("Rules:\n")
-	sb.WriteString("- Return ONLY the command, no explanation\n")
-	sb.WriteString("- Consider the workflow pattern\n")
-	sb.WriteString("- Be concise and practical\n")
-	sb.WriteString("- Do not include markdown code blocks\n\n")
-	sb.WriteString("Command:")
+	sb.WriteString("\nCommand:")
 
 	return sb.String()
 }
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"llmsh/pkg/llm"
+)
+
+func TestRankCandidates(t *testing.T) {
+	tests := []struct {
+		name    string
+		choices []string
+		want    []Candidate
+	}{
+		{
+			name:    "ordered by frequency",
+			choices: []string{"ls", "ls -la", "ls -la", "pwd"},
+			want: []Candidate{
+				{Command: "ls -la", Confidence: 0.5},
+				{Command: "ls", Confidence: 0.25},
+				{Command: "pwd", Confidence: 0.25},
+			},
+		},
+		{
+			name:    "blank answers do not count",
+			choices: []string{"", "ls", "", "ls"},
+			want:    []Candidate{{Command: "ls", Confidence: 1}},
+		},
+		{
+			name:    "all blank",
+			choices: []string{"", ""},
+			want:    []Candidate{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rankCandidates(tt.choices); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rankCandidates(%q) = %v, want %v", tt.choices, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPredictResult(t *testing.T) {
+	tests := []struct {
+		name           string
+		result         *llm.Result
+		n              int
+		wantCommand    string
+		wantCandidates int
+	}{
+		{
+			name:        "single answer",
+			result:      &llm.Result{Command: "ls", Choices: []string{"ls"}},
+			n:           1,
+			wantCommand: "ls",
+		},
+		{
+			name:           "ranked alternatives",
+			result:         &llm.Result{Command: "ls", Choices: []string{"ls", "pwd", "pwd"}},
+			n:              3,
+			wantCommand:    "pwd",
+			wantCandidates: 2,
+		},
+		{
+			name:        "every answer blank",
+			result:      &llm.Result{Command: "", Choices: []string{"", "", ""}},
+			n:           3,
+			wantCommand: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newPredictResult(tt.result, tt.n)
+			if got.Command != tt.wantCommand {
+				t.Errorf("Command = %q, want %q", got.Command, tt.wantCommand)
+			}
+			if len(got.Candidates) != tt.wantCandidates {
+				t.Errorf("got %d candidates, want %d", len(got.Candidates), tt.wantCandidates)
+			}
+		})
+	}
+}
@@ -1,32 +1,78 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/spf13/cobra"
 )
 
 // Request represents the JSON request structure from ZSH
 type Request struct {
-	Method      string   `json:"method"`
-	History     []string `json:"history,omitempty"`
-	CWD         string   `json:"cwd,omitempty"`
-	GitBranch   string   `json:"git_branch,omitempty"`
-	OSInfo      string   `json:"os_info,omitempty"`
-	Prefix      string   `json:"prefix,omitempty"`
-	Description string   `json:"description,omitempty"`
-	Timestamp   int64    `json:"timestamp,omitempty"`
+	Method    string   `json:"method"`
+	History   []string `json:"history,omitempty"`
+	CWD       string   `json:"cwd,omitempty"`
+	GitBranch string   `json:"git_branch,omitempty"`
+	OSInfo    string   `json:"os_info,omitempty"`
+	// Session identifies the shell session, and HistoryEnd is the history
+	// event number of the last command of History, so that the offline
+	// predictor learns each command once
+	Session     string `json:"session,omitempty"`
+	HistoryEnd  int64  `json:"history_end,omitempty"`
+	Prefix      string `json:"prefix,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Command is the command line to describe in explain requests
+	Command    string `json:"command,omitempty"`
+	Candidates int    `json:"candidates,omitempty"`
+	// SuggestionID, Suggestion and Executed report the fate of a suggestion
+	// in feedback requests
+	SuggestionID int64  `json:"suggestion_id,omitempty"`
+	Suggestion   string `json:"suggestion,omitempty"`
+	Executed     string `json:"executed,omitempty"`
+	Timestamp    int64  `json:"timestamp,omitempty"`
+	// Stream asks for the answer to be streamed as StreamChunk lines ahead
+	// of the response, for methods that support it
+	Stream bool `json:"stream,omitempty"`
+	// Output is the format the daemon writes the response in: json, the
+	// default, or sh for shells that talk to the socket themselves. An sh
+	// response ends with an empty line, as it spans several.
+	Output string `json:"output,omitempty"`
+
+	// emit writes a chunk of a streamed answer
+	emit func(chunk *StreamChunk)
+}
+
+// StreamChunk is written ahead of the response of a streamed request each
+// time more of the answer arrives
+type StreamChunk struct {
+	// Partial is the command received so far
+	Partial string `json:"partial"`
 }
 
 // Response represents the JSON response structure to ZSH
 type Response struct {
-	Result interface{}  `json:"result"`
-	Tokens *TokenUsage  `json:"tokens,omitempty"`
-	Error  string       `json:"error,omitempty"`
+	Result interface{} `json:"result"`
+	Tokens *TokenUsage `json:"tokens,omitempty"`
+	Error  string      `json:"error,omitempty"`
+	// ErrorCode classifies errors the shell may want to react to. It also
+	// accompanies a result served from the cache or the offline predictor
+	// after the LLM call failed.
+	ErrorCode string `json:"error_code,omitempty"`
 }
 
+// Error codes reported in Response.ErrorCode
+const (
+	ErrorCodeTimeout        = "timeout"
+	ErrorCodeCanceled       = "canceled"
+	ErrorCodeBudgetExceeded = "budget_exceeded"
+	ErrorCodeUnsafeCommand  = "unsafe_command"
+)
+
 // TokenUsage represents token usage information
 type TokenUsage struct {
 	InputTokens         int `json:"input_tokens"`
@@ -48,26 +94,35 @@ func init() {
 	rootCmd.AddCommand(predictCmd)
 	rootCmd.AddCommand(completeCmd)
 	rootCmd.AddCommand(nl2cmdCmd)
+	rootCmd.AddCommand(explainCmd)
 	rootCmd.AddCommand(statsCmd)
 	rootCmd.AddCommand(configCmd)
 	rootCmd.AddCommand(cleanCmd)
+	rootCmd.AddCommand(daemonCmd)
+	rootCmd.AddCommand(historyCmd)
+	rootCmd.AddCommand(feedbackCmd)
+	rootCmd.AddCommand(cacheCmd)
+	rootCmd.AddCommand(initCmd)
 }
 
-// Execute runs the root command
+// Execute runs the root command. SIGINT and SIGTERM cancel the command's
+// context, aborting any in-flight LLM request.
 func Execute() error {
-	return rootCmd.Execute()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return rootCmd.ExecuteContext(ctx)
 }
 
-// writeResponse writes a response to stdout as JSON
-func writeResponse(resp *Response) {
-	encoder := json.NewEncoder(os.Stdout)
-	encoder.Encode(resp)
+// encodeResponse writes a response to w as a single line of JSON
+func encodeResponse(w io.Writer, resp *Response) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(resp)
 }
 
-// writeError writes an error response to stdout
-func writeError(msg string) {
-	resp := &Response{Error: msg}
-	writeResponse(resp)
+// encodeChunk writes a chunk of a streamed answer as a single line of JSON
+func encodeChunk(w io.Writer, chunk *StreamChunk) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(chunk)
 }
 
 // readRequest reads a JSON request from stdin
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"llmsh/pkg/config"
+	"llmsh/pkg/safety"
+)
+
+// safetyLevel returns the risk from which the safety action is taken
+func (h *handler) safetyLevel() string {
+	if !safety.Valid(h.cfg.Safety.Level) {
+		return config.DefaultSafetyLevel
+	}
+	return h.cfg.Safety.Level
+}
+
+// holdsPartial reports whether a streamed partial command must not be shown,
+// because the safety action asks before inserting commands like it or
+// refuses them. Partials are shown before checkSafety sees the command, so
+// streaming stops at the first one the rules flag.
+func (h *handler) holdsPartial(partial string) bool {
+	switch h.cfg.Safety.Action {
+	case config.SafetyActionConfirm, config.SafetyActionRefuse:
+		return safety.AtLeast(safety.Check(partial).Risk, h.safetyLevel())
+	}
+	return false
+}
+
+// checkSafety rates the commands of a result with the local safety rules and
+// takes the configured action on those whose risk reaches the configured
+// level. Refused alternatives are dropped; when the top command is refused,
+// the best remaining alternative replaces it, and without one the response
+// becomes an error.
+func (h *handler) checkSafety(resp *Response) (*Response, error) {
+	action := h.cfg.Safety.Action
+	if resp == nil || action == config.SafetyActionOff {
+		return resp, nil
+	}
+	result, ok := resp.Result.(*PredictResult)
+	if !ok || result.Command == "" {
+		return resp, nil
+	}
+
+	level := h.safetyLevel()
+	flagged := func(risk string) bool {
+		return safety.AtLeast(risk, level)
+	}
+
+	// Rate the alternatives
+	var candidates []Candidate
+	for _, candidate := range result.Candidates {
+		candidate.Risk = safety.Check(candidate.Command).Risk
+		if flagged(candidate.Risk) {
+			if action == config.SafetyActionRefuse {
+				continue
+			}
+			candidate.Action = action
+		}
+		candidates = append(candidates, candidate)
+	}
+	result.Candidates = candidates
+
+	assessment := safety.Check(result.Command)
+	if flagged(assessment.Risk) && action == config.SafetyActionRefuse {
+		if len(result.Candidates) == 0 {
+			err := fmt.Errorf("refused %s risk command: %s", assessment.Risk, strings.Join(assessment.Reasons, ", "))
+			return &Response{Tokens: resp.Tokens, Error: err.Error(), ErrorCode: ErrorCodeUnsafeCommand}, err
+		}
+		result.Command = result.Candidates[0].Command
+		result.Confidence = result.Candidates[0].Confidence
+		assessment = safety.Check(result.Command)
+	}
+
+	result.Risk = assessment.Risk
+	result.RiskReasons = assessment.Reasons
+	if flagged(result.Risk) {
+		result.Action = action
+	}
+	return resp, nil
+}
@@ -3,40 +3,71 @@ package cmd
 import (
 	"fmt"
 	"sort"
+	"time"
 
+	"llmsh/pkg/config"
 	"llmsh/pkg/tracker"
 
 	"github.com/spf13/cobra"
 )
 
+var statsRepair bool
+
 var statsCmd = &cobra.Command{
 	Use:   "stats",
 	Short: "Show token usage statistics",
-	Long:  `Display token usage statistics aggregated by day and method.`,
-	RunE:  runStats,
+	Long: `Display token usage statistics aggregated by day and method.
+
+A corrupt usage store is kept aside and replaced by a fresh one; --repair
+salvages its readable records into the current store.`,
+	RunE: runStats,
+}
+
+func init() {
+	statsCmd.Flags().BoolVar(&statsRepair, "repair", false, "Recover readable records from corrupt usage stores kept aside")
 }
 
 func runStats(cmd *cobra.Command, args []string) error {
+	// Costs and acceptance need the configuration, token counts do not
+	cfg, _ := config.Load()
+	if cfg != nil {
+		tracker.SetDBPath(cfg.Tracking.DBPath)
+		tracker.SetPricing(cfg.Price)
+	}
+	defer tracker.Close()
+
+	if statsRepair {
+		if err := repairUsage(); err != nil {
+			return err
+		}
+	}
+
 	// Load token records
-	storage, err := tracker.LoadRecords()
+	summaries, err := tracker.Summarize(time.Time{})
 	if err != nil {
 		fmt.Printf("Error loading records: %v\n", err)
 		return err
 	}
 
-	if len(storage.Records) == 0 {
+	// Point at corrupt stores that still hold records
+	if backups, _ := tracker.Backups(); len(backups) > 0 && !statsRepair {
+		fmt.Printf("Found %d corrupt usage store(s) kept aside; run 'llmsh stats --repair' to recover their records.\n\n", len(backups))
+	}
+
+	if len(summaries) == 0 {
 		fmt.Println("No usage records found.")
+		printAcceptance(cfg)
 		return nil
 	}
 
 	// Aggregate by day
-	dayStats := tracker.AggregateByDay(storage.Records)
+	dayStats := tracker.AggregateByDay(summaries)
 
 	// Aggregate by method
-	methodStats := tracker.AggregateByMethod(storage.Records)
+	methodStats := tracker.AggregateByMethod(summaries)
 
 	// Aggregate by provider/model
-	providerModelStats := tracker.AggregateByProviderModel(storage.Records)
+	providerModelStats := tracker.AggregateByProviderModel(summaries)
 
 	// Print statistics
 	fmt.Println("Token Usage Statistics")
@@ -52,9 +83,13 @@ func runStats(cmd *cobra.Command, args []string) error {
 		fmt.Printf("  Requests:      %d\n", stat.Count)
 		fmt.Printf("  Input Tokens:  %d\n", stat.InputTokens)
 		fmt.Printf("  Output Tokens: %d\n", stat.OutputTokens)
+		if stat.CacheCreationTokens > 0 {
+			fmt.Printf("  Cache Write:   %d\n", stat.CacheCreationTokens)
+		}
 		if stat.CacheReadTokens > 0 {
 			fmt.Printf("  Cache Read:    %d\n", stat.CacheReadTokens)
 		}
+		printCost("  Cost:          ", stat.Costs)
 		fmt.Println()
 	}
 
@@ -72,7 +107,9 @@ func runStats(cmd *cobra.Command, args []string) error {
 	totalRequests := 0
 	totalInput := 0
 	totalOutput := 0
+	totalCacheCreation := 0
 	totalCacheRead := 0
+	var totalCosts tracker.Costs
 
 	for _, day := range days {
 		stat := dayStats[day]
@@ -80,15 +117,22 @@ func runStats(cmd *cobra.Command, args []string) error {
 		fmt.Printf("  Requests:      %d\n", stat.Count)
 		fmt.Printf("  Input Tokens:  %d\n", stat.InputTokens)
 		fmt.Printf("  Output Tokens: %d\n", stat.OutputTokens)
+		if stat.CacheCreationTokens > 0 {
+			fmt.Printf("  Cache Write:   %d\n", stat.CacheCreationTokens)
+		}
 		if stat.CacheReadTokens > 0 {
 			fmt.Printf("  Cache Read:    %d\n", stat.CacheReadTokens)
 		}
+		printCost("  Cost:          ", stat.Costs)
 		fmt.Println()
 
 		totalRequests += stat.Count
 		totalInput += stat.InputTokens
 		totalOutput += stat.OutputTokens
+		totalCacheCreation += stat.CacheCreationTokens
 		totalCacheRead += stat.CacheReadTokens
+		totalCosts.Cost += stat.Cost
+		totalCosts.Unpriced += stat.Unpriced
 	}
 
 	// Print by method
@@ -100,6 +144,7 @@ func runStats(cmd *cobra.Command, args []string) error {
 		fmt.Printf("  Requests:      %d\n", stat.Count)
 		fmt.Printf("  Input Tokens:  %d\n", stat.InputTokens)
 		fmt.Printf("  Output Tokens: %d\n", stat.OutputTokens)
+		printCost("  Cost:          ", stat.Costs)
 		fmt.Println()
 	}
 
@@ -109,12 +154,92 @@ func runStats(cmd *cobra.Command, args []string) error {
 	fmt.Printf("  Total Requests:      %d\n", totalRequests)
 	fmt.Printf("  Total Input Tokens:  %d\n", totalInput)
 	fmt.Printf("  Total Output Tokens: %d\n", totalOutput)
+	if totalCacheCreation > 0 {
+		fmt.Printf("  Total Cache Write:   %d\n", totalCacheCreation)
+	}
 	if totalCacheRead > 0 {
 		fmt.Printf("  Total Cache Read:    %d\n", totalCacheRead)
 		// Calculate potential savings from cache
 		savingsPercent := float64(totalCacheRead) / float64(totalInput+totalCacheRead) * 100
 		fmt.Printf("  Cache Savings:       %.1f%%\n", savingsPercent)
 	}
+	printCost("  Total Cost:          ", totalCosts)
+
+	printAcceptance(cfg)
+	return nil
+}
+
+// repairUsage salvages records from corrupt usage stores and reports what
+// was recovered
+func repairUsage() error {
+	results, err := tracker.Repair()
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Printf("✗ %s: %v\n", result.Backup, result.Err)
+		} else {
+			fmt.Printf("✓ %s: recovered %d records\n", result.Backup, result.Recovered)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("repair: %w", err)
+	}
 
+	if len(results) == 0 {
+		fmt.Println("No corrupt usage stores to repair.")
+	}
+	fmt.Println()
 	return nil
 }
+
+// printCost prints a cost line when any of the records has a price, noting
+// how many do not
+func printCost(label string, costs tracker.Costs) {
+	if costs.Cost == 0 && costs.Unpriced > 0 {
+		return
+	}
+	if costs.Unpriced > 0 {
+		fmt.Printf("%s$%.4f (%d requests without a price)\n", label, costs.Cost, costs.Unpriced)
+	} else {
+		fmt.Printf("%s$%.4f\n", label, costs.Cost)
+	}
+}
+
+// printAcceptance prints how suggestions were received, as recorded by
+// feedback requests in the cache database
+func printAcceptance(cfg *config.Config) {
+	if cfg == nil || !cfg.Cache.Enabled {
+		return
+	}
+
+	cacheDB, err := openCache(cfg)
+	if err != nil {
+		return
+	}
+	defer cacheDB.Close()
+
+	stats, err := cacheDB.Acceptance()
+	if err != nil || len(stats) == 0 {
+		return
+	}
+
+	fmt.Println()
+	fmt.Println("Acceptance by Method/Provider/Model:")
+	fmt.Println("------------------------------------")
+
+	for _, stat := range stats {
+		// Cached answers have no model
+		if stat.Model != "" {
+			fmt.Printf("%s / %s / %s:\n", stat.Method, stat.Provider, stat.Model)
+		} else {
+			fmt.Printf("%s / %s:\n", stat.Method, stat.Provider)
+		}
+		fmt.Printf("  Suggestions:   %d\n", stat.Suggested)
+		fmt.Printf("  Accepted:      %d\n", stat.Accepted)
+		fmt.Printf("  Edited:        %d\n", stat.Edited)
+		fmt.Printf("  Discarded:     %d\n", stat.Discarded)
+		if stat.Accepted+stat.Edited+stat.Discarded > 0 {
+			fmt.Printf("  Acceptance:    %.1f%%\n", stat.Rate()*100)
+		}
+		fmt.Println()
+	}
+}
@@ -36,67 +36,6 @@ detect_platform() {
     echo "${os}-${arch}"
 }
 
-# Install jq based on OS
-install_jq() {
-    if command -v jq &>/dev/null; then
-        echo "✓ jq is already installed"
-        return 0
-    fi
-
-    echo "Installing jq..."
-
-    case "$(uname -s)" in
-        Darwin*)
-            if command -v brew &>/dev/null; then
-                brew install jq
-            else
-                echo "❌ Error: Homebrew is required to install jq on macOS"
-                echo "Please install Homebrew from https://brew.sh/"
-                echo "Or install jq manually from https://stedolan.github.io/jq/"
-                exit 1
-            fi
-            ;;
-        Linux*)
-            if [ -f /etc/debian_version ]; then
-                # Debian/Ubuntu
-                if command -v sudo &>/dev/null; then
-                    sudo apt-get update && sudo apt-get install -y jq
-                else
-                    echo "⚠️  Warning: sudo not available, trying without sudo..."
-                    apt-get update && apt-get install -y jq
-                fi
-            elif [ -f /etc/redhat-release ]; then
-                # RHEL/CentOS/Fedora
-                if command -v sudo &>/dev/null; then
-                    sudo yum install -y jq
-                else
-                    echo "⚠️  Warning: sudo not available, trying without sudo..."
-                    yum install -y jq
-                fi
-            elif [ -f /etc/arch-release ]; then
-                # Arch Linux
-                if command -v sudo &>/dev/null; then
-                    sudo pacman -S --noconfirm jq
-                else
-                    echo "⚠️  Warning: sudo not available, trying without sudo..."
-                    pacman -S --noconfirm jq
-                fi
-            else
-                echo "❌ Error: Unsupported Linux distribution"
-                echo "Please install jq manually: https://stedolan.github.io/jq/"
-                exit 1
-            fi
-            ;;
-        *)
-            echo "❌ Error: Cannot install jq automatically on this system"
-            echo "Please install jq manually from https://stedolan.github.io/jq/"
-            exit 1
-            ;;
-    esac
-
-    echo "✓ jq installed successfully"
-}
-
 # Uninstall function
 uninstall() {
     echo "========================================="
@@ -198,9 +137,6 @@ else
     echo "✓ zsh found: $(zsh --version)"
 fi
 
-# Install jq if needed
-install_jq
-
 # Detect platform
 PLATFORM=$(detect_platform)
 echo "✓ Detected platform: $PLATFORM"
@@ -305,4 +241,9 @@ echo ""
 echo "3. Reload your shell:"
 echo "   source ~/.zshrc"
 echo ""
+echo "Using bash? Add this line to your ~/.bashrc instead:"
+echo "   eval \"\$($BINARY init bash)\""
+echo "Using fish? Add this line to your ~/.config/fish/config.fish instead:"
+echo "   $BINARY init fish | source"
+echo ""
 echo "For more information, see README.md and USAGE.md"
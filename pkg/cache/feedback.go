@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"time"
+)
+
+// Outcomes of a suggestion, as reported by the shell
+const (
+	OutcomeAccepted  = "accepted"
+	OutcomeEdited    = "edited"
+	OutcomeDiscarded = "discarded"
+)
+
+// Suggestion represents a command suggested to the user
+type Suggestion struct {
+	ID        int64
+	Method    string
+	Provider  string
+	Model     string
+	Command   string
+	Outcome   string
+	Executed  string
+	CreatedAt time.Time
+}
+
+// How long suggestions are kept. Shells report a suggestion once the next
+// command line runs, so one still awaiting feedback a day later never gets
+// any; answered ones are kept longer for the acceptance statistics.
+const (
+	suggestionFeedbackWindow = 24 * time.Hour
+	suggestionRetention      = 90 * 24 * time.Hour
+)
+
+// AcceptanceStats represents how suggestions of one method, provider and
+// model were received
+type AcceptanceStats struct {
+	Method    string
+	Provider  string
+	Model     string
+	Suggested int
+	Accepted  int
+	Edited    int
+	Discarded int
+}
+
+// Rate returns the share of suggestions with feedback that were executed
+// verbatim
+func (s *AcceptanceStats) Rate() float64 {
+	answered := s.Accepted + s.Edited + s.Discarded
+	if answered == 0 {
+		return 0
+	}
+	return float64(s.Accepted) / float64(answered)
+}
+
+// AddSuggestion stores a suggestion awaiting feedback and returns its ID.
+// Every CleanupInterval, it also evicts old suggestions along with expired
+// and excess entries.
+func (c *Cache) AddSuggestion(s *Suggestion) (int64, error) {
+	result, err := c.db.Exec(`
+		INSERT INTO suggestions (method, provider, model, command, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, s.Method, s.Provider, s.Model, s.Command, time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+
+	c.maybeCleanup()
+	return result.LastInsertId()
+}
+
+// pruneSuggestions removes the suggestions that will get no feedback and
+// those older than the retention period
+func (c *Cache) pruneSuggestions() error {
+	now := time.Now()
+	_, err := c.db.Exec(`
+		DELETE FROM suggestions
+		WHERE (outcome IS NULL AND created_at < ?) OR created_at < ?
+	`, now.Add(-suggestionFeedbackWindow).Unix(), now.Add(-suggestionRetention).Unix())
+	return err
+}
+
+// GetSuggestion retrieves a suggestion by ID
+func (c *Cache) GetSuggestion(id int64) (*Suggestion, error) {
+	var s Suggestion
+	var createdAt int64
+	err := c.db.QueryRow(`
+		SELECT id, method, provider, model, command,
+			COALESCE(outcome, ''), COALESCE(executed, ''), created_at
+		FROM suggestions
+		WHERE id = ?
+	`, id).Scan(&s.ID, &s.Method, &s.Provider, &s.Model, &s.Command, &s.Outcome, &s.Executed, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	s.CreatedAt = time.Unix(createdAt, 0)
+	return &s, nil
+}
+
+// RecordFeedback stores the outcome of a suggestion. The command shown may
+// differ from the stored one when the user cycled to an alternative.
+func (c *Cache) RecordFeedback(id int64, command, executed, outcome string) error {
+	_, err := c.db.Exec(`
+		UPDATE suggestions
+		SET command = ?, executed = ?, outcome = ?, feedback_at = ?
+		WHERE id = ?
+	`, command, executed, outcome, time.Now().Unix(), id)
+	return err
+}
+
+// Acceptance aggregates suggestion outcomes by method, provider and model
+func (c *Cache) Acceptance() ([]AcceptanceStats, error) {
+	rows, err := c.db.Query(`
+		SELECT method, provider, model, COUNT(*),
+			COUNT(CASE WHEN outcome = ? THEN 1 END),
+			COUNT(CASE WHEN outcome = ? THEN 1 END),
+			COUNT(CASE WHEN outcome = ? THEN 1 END)
+		FROM suggestions
+		GROUP BY method, provider, model
+		ORDER BY method, provider, model
+	`, OutcomeAccepted, OutcomeEdited, OutcomeDiscarded)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []AcceptanceStats
+	for rows.Next() {
+		var s AcceptanceStats
+		if err := rows.Scan(&s.Method, &s.Provider, &s.Model, &s.Suggested, &s.Accepted, &s.Edited, &s.Discarded); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strconv"
+)
+
+// KeyVersion identifies the scheme Key derives keys with. Bumping it drops
+// every entry keyed by an earlier scheme when a cache is opened.
+const KeyVersion = 2
+
+// KeyOptions selects the parts of a request's context that its key covers
+type KeyOptions struct {
+	// HistoryLength is how many of the last commands are covered; negative
+	// counts as zero
+	HistoryLength int
+	CWD           bool
+	GitBranch     bool
+	OSInfo        bool
+}
+
+// scheme describes the keys derived with the options, so that entries keyed
+// differently can be recognized
+func (o KeyOptions) scheme() string {
+	return fmt.Sprintf("v%d history=%d cwd=%t git_branch=%t os=%t",
+		KeyVersion, o.HistoryLength, o.CWD, o.GitBranch, o.OSInfo)
+}
+
+// KeyInput is a request to derive a cache key for
+type KeyInput struct {
+	Method string
+	// Input is the prefix or description of the request, if any
+	Input     string
+	History   []string
+	CWD       string
+	GitBranch string
+	OSInfo    string
+}
+
+// Key derives the cache key of a request from its method, input and the
+// parts of its context selected by opts. Every part is length-prefixed, so
+// different requests never encode alike.
+func Key(in KeyInput, opts KeyOptions) string {
+	h := sha256.New()
+	writeField(h, strconv.Itoa(KeyVersion))
+	writeField(h, in.Method)
+	writeField(h, in.Input)
+
+	history := in.History
+	if keep := max(opts.HistoryLength, 0); len(history) > keep {
+		history = history[len(history)-keep:]
+	}
+	writeField(h, strconv.Itoa(len(history)))
+	for _, cmd := range history {
+		writeField(h, cmd)
+	}
+
+	// Excluded parts are written empty, keeping the others in place
+	var cwd, gitBranch, osInfo string
+	if opts.CWD {
+		cwd = in.CWD
+	}
+	if opts.GitBranch {
+		gitBranch = in.GitBranch
+	}
+	if opts.OSInfo {
+		osInfo = in.OSInfo
+	}
+	writeField(h, cwd)
+	writeField(h, gitBranch)
+	writeField(h, osInfo)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeField writes a string prefixed with its length
+func writeField(h hash.Hash, s string) {
+	fmt.Fprintf(h, "%d:%s", len(s), s)
+}
+
+// keySchemePrefix, followed by a method, records in the meta table the
+// scheme the entries of the method are keyed with
+const keySchemePrefix = "key_scheme:"
+
+// dropStaleEntries removes the entries of every method in keys that were
+// keyed with another scheme, since no lookup can find them anymore
+func dropStaleEntries(db *sql.DB, keys map[string]KeyOptions) error {
+	for method, opts := range keys {
+		if err := dropStaleMethod(db, method, opts.scheme()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropStaleMethod removes the entries of a method unless they are keyed with
+// the scheme, and records the scheme
+func dropStaleMethod(db *sql.DB, method, scheme string) error {
+	var stored string
+	err := db.QueryRow("SELECT value FROM meta WHERE key = ?", keySchemePrefix+method).Scan(&stored)
+	if err == nil && stored == scheme {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Another process may have dropped them meanwhile
+	err = tx.QueryRow("SELECT value FROM meta WHERE key = ?", keySchemePrefix+method).Scan(&stored)
+	if err == nil && stored == scheme {
+		return nil
+	}
+
+	if _, err := tx.Exec("DELETE FROM predictions WHERE method = ?", method); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO meta (key, value) VALUES (?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value
+	`, keySchemePrefix+method, scheme)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestKey(t *testing.T) {
+	base := KeyInput{
+		Method:    "predict",
+		Input:     "git",
+		History:   []string{"cd repo", "git status", "git add ."},
+		CWD:       "/repo",
+		GitBranch: "main",
+		OSInfo:    "Linux",
+	}
+	all := KeyOptions{HistoryLength: 2, CWD: true, GitBranch: true, OSInfo: true}
+
+	tests := []struct {
+		name  string
+		a, b  KeyInput
+		opts  KeyOptions
+		equal bool
+	}{
+		{
+			name:  "same request",
+			a:     base,
+			b:     base,
+			opts:  all,
+			equal: true,
+		},
+		{
+			name:  "older history is not covered",
+			a:     base,
+			b:     with(base, func(in *KeyInput) { in.History = []string{"ls", "git status", "git add ."} }),
+			opts:  all,
+			equal: true,
+		},
+		{
+			name: "recent history is covered",
+			a:    base,
+			b:    with(base, func(in *KeyInput) { in.History = []string{"cd repo", "git diff", "git add ."} }),
+			opts: all,
+		},
+		{
+			name:  "negative history length covers none",
+			a:     base,
+			b:     with(base, func(in *KeyInput) { in.History = nil }),
+			opts:  KeyOptions{HistoryLength: -1, CWD: true, GitBranch: true, OSInfo: true},
+			equal: true,
+		},
+		{
+			name: "directory is covered",
+			a:    base,
+			b:    with(base, func(in *KeyInput) { in.CWD = "/other" }),
+			opts: all,
+		},
+		{
+			name:  "excluded directory is not",
+			a:     base,
+			b:     with(base, func(in *KeyInput) { in.CWD = "/other" }),
+			opts:  KeyOptions{HistoryLength: 2, GitBranch: true, OSInfo: true},
+			equal: true,
+		},
+		{
+			name:  "excluded branch and OS are not",
+			a:     base,
+			b:     with(base, func(in *KeyInput) { in.GitBranch, in.OSInfo = "dev", "macOS" }),
+			opts:  KeyOptions{HistoryLength: 2, CWD: true},
+			equal: true,
+		},
+		{
+			name: "method",
+			a:    base,
+			b:    with(base, func(in *KeyInput) { in.Method = "complete" }),
+			opts: all,
+		},
+		{
+			name: "fields do not run together",
+			a:    with(base, func(in *KeyInput) { in.Input, in.History = "git", []string{"a b"} }),
+			b:    with(base, func(in *KeyInput) { in.Input, in.History = "git", []string{"a", "b"} }),
+			opts: KeyOptions{HistoryLength: 2},
+		},
+		{
+			name: "parts keep their place",
+			a:    with(base, func(in *KeyInput) { in.CWD, in.GitBranch = "main", "" }),
+			b:    with(base, func(in *KeyInput) { in.CWD, in.GitBranch = "", "main" }),
+			opts: all,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := Key(tt.a, tt.opts), Key(tt.b, tt.opts)
+			if (a == b) != tt.equal {
+				t.Errorf("keys equal = %v, want %v", a == b, tt.equal)
+			}
+		})
+	}
+}
+
+// with returns a copy of a key input changed by fn
+func with(in KeyInput, fn func(*KeyInput)) KeyInput {
+	in.History = append([]string(nil), in.History...)
+	fn(&in)
+	return in
+}
+
+func TestDropStaleEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	predict := KeyOptions{HistoryLength: 3, CWD: true}
+	complete := KeyOptions{CWD: true}
+
+	open := func(predict KeyOptions) *Cache {
+		t.Helper()
+		c, err := Open(path, Options{Keys: map[string]KeyOptions{"predict": predict, "complete": complete}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	c := open(predict)
+	c.Set(&CacheEntry{ContextHash: "p", Method: "predict", Command: "make"})
+	c.Set(&CacheEntry{ContextHash: "c", Method: "complete", Command: "make test"})
+	c.Close()
+
+	tests := []struct {
+		name      string
+		predict   KeyOptions
+		wantCount int
+	}{
+		{name: "same scheme keeps entries", predict: predict, wantCount: 2},
+		{name: "another scheme drops the method's entries", predict: KeyOptions{HistoryLength: 1, CWD: true}, wantCount: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := open(tt.predict)
+			defer c.Close()
+
+			total, _, err := c.Stats()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total != tt.wantCount {
+				t.Errorf("%d entries left, want %d", total, tt.wantCount)
+			}
+			if c.Get("complete", "c") == nil {
+				t.Error("entry of another method was dropped")
+			}
+		})
+	}
+}
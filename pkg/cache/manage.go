@@ -0,0 +1,379 @@
+package cache
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrEntryNotFound is returned when no entry matches a key
+	ErrEntryNotFound = errors.New("cache entry not found")
+	// ErrAmbiguousKey is returned when a key prefix matches several entries
+	ErrAmbiguousKey = errors.New("cache key prefix is ambiguous")
+)
+
+// Filter selects cache entries; empty fields match every entry
+type Filter struct {
+	// KeyPrefix matches context hashes starting with it
+	KeyPrefix string
+	// Method matches entries of the method
+	Method string
+	// CWD matches entries predicted in the directory or below it
+	CWD string
+	// Pattern is a glob, as in the shell, matched against the command
+	Pattern string
+	// Limit caps the number of entries listed; zero means no limit
+	Limit int
+}
+
+// IsZero reports whether the filter matches every entry
+func (f Filter) IsZero() bool {
+	return f.KeyPrefix == "" && f.Method == "" && f.CWD == "" && f.Pattern == ""
+}
+
+// where returns the SQL condition for the filter and its arguments
+func (f Filter) where() (string, []any) {
+	conds := []string{"1"}
+	var args []any
+
+	if f.KeyPrefix != "" {
+		conds = append(conds, "instr(context_hash, ?) = 1")
+		args = append(args, strings.ToLower(f.KeyPrefix))
+	}
+	if f.Method != "" {
+		conds = append(conds, "method = ?")
+		args = append(args, f.Method)
+	}
+	if f.CWD != "" {
+		dir := filepath.Clean(f.CWD)
+		prefix := dir + "/"
+		if dir == "/" {
+			prefix = dir
+		}
+		conds = append(conds, "(cwd = ? OR instr(cwd, ?) = 1)")
+		args = append(args, dir, prefix)
+	}
+	if f.Pattern != "" {
+		conds = append(conds, "command GLOB ?")
+		args = append(args, f.Pattern)
+	}
+
+	return strings.Join(conds, " AND "), args
+}
+
+// List returns the entries matching a filter, most recently used first
+func (c *Cache) List(f Filter) ([]CacheEntry, error) {
+	where, args := f.where()
+	query := "SELECT " + entryColumns + " FROM predictions WHERE " + where +
+		" ORDER BY last_used DESC"
+	if f.Limit > 0 {
+		query += " LIMIT " + strconv.Itoa(f.Limit)
+	}
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []CacheEntry
+	for rows.Next() {
+		entry, err := scanEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+	return entries, rows.Err()
+}
+
+// Lookup returns the single entry whose context hash starts with a prefix
+func (c *Cache) Lookup(keyPrefix string) (*CacheEntry, error) {
+	entries, err := c.List(Filter{KeyPrefix: keyPrefix, Limit: 2})
+	if err != nil {
+		return nil, err
+	}
+	switch len(entries) {
+	case 0:
+		return nil, ErrEntryNotFound
+	case 1:
+		return &entries[0], nil
+	default:
+		return nil, ErrAmbiguousKey
+	}
+}
+
+// Delete removes the entries matching a filter, ignoring its limit, and
+// returns how many were removed. A zero filter removes every entry.
+func (c *Cache) Delete(f Filter) (int, error) {
+	where, args := f.where()
+	result, err := c.db.Exec("DELETE FROM predictions WHERE "+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// Clear removes every entry along with the lookup counters of the hit rate,
+// and reclaims the space they used. Suggestions and their feedback are kept.
+// It returns the number of entries removed.
+func (c *Cache) Clear() (int, error) {
+	removed, err := c.Delete(Filter{})
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = c.db.Exec("DELETE FROM meta WHERE instr(key, ?) = 1 OR instr(key, ?) = 1", hitsPrefix, missesPrefix)
+	if err != nil {
+		return removed, err
+	}
+
+	_, err = c.db.Exec("VACUUM")
+	return removed, err
+}
+
+// Import stores entries as they are, replacing those with the same context
+// hash, and returns how many were stored. Entries without timestamps are
+// stamped with the current time, and those without a method are taken for
+// predictions.
+func (c *Cache) Import(entries []CacheEntry) (int, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	for _, entry := range entries {
+		if entry.Method == "" {
+			entry.Method = defaultMethod
+		}
+		if entry.CreatedAt.IsZero() {
+			entry.CreatedAt = now
+		}
+		if entry.LastUsed.IsZero() {
+			entry.LastUsed = entry.CreatedAt
+		}
+
+		_, err := tx.Exec(`
+			INSERT OR REPLACE INTO predictions
+			(context_hash, method, command, cwd, git_branch, created_at, hit_count, last_used)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`, entry.ContextHash, entry.Method, entry.Command, entry.CWD, entry.GitBranch,
+			entry.CreatedAt.Unix(), entry.HitCount, entry.LastUsed.Unix())
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// Summary describes the contents of the cache and how well it answers
+type Summary struct {
+	Entries int
+	// Expired counts entries past the TTL that await eviction
+	Expired int
+	// TotalHits sums the hit counts of the entries
+	TotalHits int64
+	// Hits and Misses count lookups since the cache was created
+	Hits   int64
+	Misses int64
+	// Methods breaks entries and lookups down by method
+	Methods []MethodSummary
+	// TopCommands lists the most hit commands, most hit first
+	TopCommands []CommandHits
+	// Ages counts entries by age, youngest first
+	Ages []AgeBucket
+}
+
+// MethodSummary describes the entries and lookups of one method
+type MethodSummary struct {
+	Method  string
+	Entries int
+	Hits    int64
+	Misses  int64
+}
+
+// HitRate returns the share of lookups of the method answered from the cache
+func (s *MethodSummary) HitRate() float64 {
+	return hitRate(s.Hits, s.Misses)
+}
+
+// CommandHits represents how often a cached command was served
+type CommandHits struct {
+	Command string
+	Entries int
+	Hits    int64
+}
+
+// AgeBucket counts the entries created less than MaxAge ago and not counted
+// by a younger bucket; the last bucket has no MaxAge
+type AgeBucket struct {
+	Label   string
+	MaxAge  time.Duration
+	Entries int
+}
+
+// ageBuckets are the buckets of Summary.Ages
+var ageBuckets = []AgeBucket{
+	{Label: "< 1 hour", MaxAge: time.Hour},
+	{Label: "< 1 day", MaxAge: 24 * time.Hour},
+	{Label: "< 1 week", MaxAge: 7 * 24 * time.Hour},
+	{Label: "< 30 days", MaxAge: 30 * 24 * time.Hour},
+	{Label: "older"},
+}
+
+// HitRate returns the share of lookups answered from the cache
+func (s *Summary) HitRate() float64 {
+	return hitRate(s.Hits, s.Misses)
+}
+
+// hitRate returns the share of lookups that hit
+func hitRate(hits, misses int64) float64 {
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+// Summarize describes the cache, listing up to top commands
+func (c *Cache) Summarize(top int) (*Summary, error) {
+	s := &Summary{}
+
+	var err error
+	s.Entries, s.TotalHits, err = c.Stats()
+	if err != nil {
+		return nil, err
+	}
+
+	where, args := c.expired()
+	if err := c.db.QueryRow("SELECT COUNT(*) FROM predictions WHERE "+where, args...).Scan(&s.Expired); err != nil {
+		return nil, err
+	}
+
+	if s.Methods, err = c.summarizeMethods(); err != nil {
+		return nil, err
+	}
+	for _, method := range s.Methods {
+		s.Hits += method.Hits
+		s.Misses += method.Misses
+	}
+
+	rows, err := c.db.Query(`
+		SELECT command, COUNT(*), COALESCE(SUM(hit_count), 0) AS hits
+		FROM predictions
+		GROUP BY command
+		HAVING hits > 0
+		ORDER BY hits DESC, command
+		LIMIT ?
+	`, top)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ch CommandHits
+		if err := rows.Scan(&ch.Command, &ch.Entries, &ch.Hits); err != nil {
+			return nil, err
+		}
+		s.TopCommands = append(s.TopCommands, ch)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Count each bucket as the entries younger than it minus younger buckets
+	now := time.Now()
+	counted := 0
+	for _, bucket := range ageBuckets {
+		var younger int
+		if bucket.MaxAge > 0 {
+			cutoff := now.Add(-bucket.MaxAge).Unix()
+			err := c.db.QueryRow("SELECT COUNT(*) FROM predictions WHERE created_at > ?", cutoff).Scan(&younger)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			younger = s.Entries
+		}
+		bucket.Entries = younger - counted
+		counted = younger
+		s.Ages = append(s.Ages, bucket)
+	}
+
+	return s, nil
+}
+
+// summarizeMethods counts the entries and lookups of every method seen,
+// ordered by method
+func (c *Cache) summarizeMethods() ([]MethodSummary, error) {
+	methods := make(map[string]*MethodSummary)
+	method := func(name string) *MethodSummary {
+		if methods[name] == nil {
+			methods[name] = &MethodSummary{Method: name}
+		}
+		return methods[name]
+	}
+
+	rows, err := c.db.Query("SELECT method, COUNT(*) FROM predictions GROUP BY method")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var entries int
+		if err := rows.Scan(&name, &entries); err != nil {
+			return nil, err
+		}
+		method(name).Entries = entries
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	counters, err := c.db.Query(`
+		SELECT key, value FROM meta
+		WHERE instr(key, ?) = 1 OR instr(key, ?) = 1
+	`, hitsPrefix, missesPrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer counters.Close()
+
+	for counters.Next() {
+		var key, value string
+		if err := counters.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		n, _ := strconv.ParseInt(value, 10, 64)
+		if name, ok := strings.CutPrefix(key, hitsPrefix); ok {
+			method(name).Hits = n
+		} else if name, ok := strings.CutPrefix(key, missesPrefix); ok {
+			method(name).Misses = n
+		}
+	}
+	if err := counters.Err(); err != nil {
+		return nil, err
+	}
+
+	summaries := make([]MethodSummary, 0, len(methods))
+	for _, summary := range methods {
+		summaries = append(summaries, *summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Method < summaries[j].Method
+	})
+	return summaries, nil
+}
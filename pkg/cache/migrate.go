@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migrations upgrade the schema one version at a time. The schema created by
+// Open is version 0, and migrations[i] upgrades version i to i+1, as
+// recorded in PRAGMA user_version.
+var migrations = []string{
+	// 1: remember where entries were predicted, to inspect and drop them
+	`ALTER TABLE predictions ADD COLUMN cwd TEXT NOT NULL DEFAULT '';
+	ALTER TABLE predictions ADD COLUMN git_branch TEXT NOT NULL DEFAULT '';
+	CREATE INDEX IF NOT EXISTS idx_cwd ON predictions(cwd);`,
+	// 2: cache complete and nl2cmd answers next to predictions
+	`ALTER TABLE predictions ADD COLUMN method TEXT NOT NULL DEFAULT 'predict';
+	CREATE INDEX IF NOT EXISTS idx_method_created_at ON predictions(method, created_at);`,
+	// 3: embeddings of nl2cmd descriptions, for semantic lookups
+	`ALTER TABLE predictions ADD COLUMN embedding BLOB;
+	ALTER TABLE predictions ADD COLUMN embedding_model TEXT NOT NULL DEFAULT '';`,
+}
+
+// migrate applies pending migrations. Each runs in a write transaction that
+// re-reads the version, so concurrent processes apply it only once.
+func migrate(db *sql.DB) error {
+	for {
+		done, err := migrateOnce(db)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// migrateOnce applies the next pending migration, if any, and reports
+// whether the schema is up to date
+func migrateOnce(db *sql.DB) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return false, err
+	}
+	if version >= len(migrations) {
+		return true, nil
+	}
+
+	if _, err := tx.Exec(migrations[version]); err != nil {
+		return false, fmt.Errorf("migrate cache to version %d: %w", version+1, err)
+	}
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+		return false, err
+	}
+	return false, tx.Commit()
+}
@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Similar returns the unexpired entry of a method whose embedding, made with
+// the same model, is the most similar to vector, along with their cosine
+// similarity. It returns nil when no entry reaches threshold.
+//
+// Entries are compared one by one, which is fast enough for the few
+// thousand entries a cache holds.
+func (c *Cache) Similar(method, model string, vector []float32, threshold float64) (*CacheEntry, float64, error) {
+	query := normalize(vector)
+	if query == nil {
+		return nil, 0, nil
+	}
+
+	rows, err := c.db.Query(`
+		SELECT context_hash, embedding
+		FROM predictions
+		WHERE method = ? AND embedding_model = ? AND embedding IS NOT NULL
+			AND created_at >= ?
+	`, method, model, c.expiryCutoff(method))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var best string
+	bestSimilarity := threshold
+	for rows.Next() {
+		var contextHash string
+		var blob []byte
+		if err := rows.Scan(&contextHash, &blob); err != nil {
+			return nil, 0, err
+		}
+
+		// Stored vectors are normalized, so their dot product is the cosine
+		stored := decodeVector(blob)
+		if len(stored) != len(query) {
+			continue
+		}
+		var similarity float64
+		for i := range query {
+			similarity += float64(query[i]) * float64(stored[i])
+		}
+		if similarity >= bestSimilarity {
+			best, bestSimilarity = contextHash, similarity
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	if best == "" {
+		return nil, 0, nil
+	}
+
+	entry, err := scanEntry(c.db.QueryRow(`
+		SELECT `+entryColumns+`
+		FROM predictions
+		WHERE context_hash = ?
+	`, best))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	c.touch(best)
+	return entry, bestSimilarity, nil
+}
+
+// normalize scales a vector to unit length, or returns nil for a zero vector
+func normalize(vector []float32) []float32 {
+	var norm float64
+	for _, value := range vector {
+		norm += float64(value) * float64(value)
+	}
+	if norm == 0 {
+		return nil
+	}
+	norm = math.Sqrt(norm)
+
+	normalized := make([]float32, len(vector))
+	for i, value := range vector {
+		normalized[i] = float32(float64(value) / norm)
+	}
+	return normalized
+}
+
+// encodeVector normalizes a vector and encodes it as little-endian float32s,
+// or returns nil for an empty or zero vector
+func encodeVector(vector []float32) []byte {
+	normalized := normalize(vector)
+	if normalized == nil {
+		return nil
+	}
+
+	blob := make([]byte, 4*len(normalized))
+	for i, value := range normalized {
+		binary.LittleEndian.PutUint32(blob[4*i:], math.Float32bits(value))
+	}
+	return blob
+}
+
+// decodeVector decodes a vector encoded by encodeVector
+func decodeVector(blob []byte) []float32 {
+	vector := make([]float32, len(blob)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(blob[4*i:]))
+	}
+	return vector
+}
@@ -4,34 +4,77 @@ import (
 	"database/sql"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// DefaultCleanupInterval is how often writes evict entries when Options
+// sets no interval
+const DefaultCleanupInterval = 24 * time.Hour
+
+// Keys of the meta table
+const (
+	// lastCleanupKey records when entries were last evicted
+	lastCleanupKey = "last_cleanup"
+	// hitsPrefix and missesPrefix, followed by a method, count lookups for
+	// the hit rate
+	hitsPrefix   = "hits:"
+	missesPrefix = "misses:"
+)
+
+// defaultMethod is the method of entries stored before the cache kept
+// answers of other methods
+const defaultMethod = "predict"
+
 // Cache represents a SQLite-backed cache
 type Cache struct {
-	db *sql.DB
+	db   *sql.DB
+	opts Options
+}
+
+// Options controls how long entries live and how many are kept
+type Options struct {
+	// TTL is the age at which entries expire; zero means never
+	TTL time.Duration
+	// MethodTTLs overrides TTL for the entries of some methods
+	MethodTTLs map[string]time.Duration
+	// MaxEntries caps the number of entries; zero means no limit
+	MaxEntries int
+	// CleanupInterval is how often writes evict expired and excess entries
+	CleanupInterval time.Duration
+	// Keys lists how the entries of each method are keyed; entries keyed
+	// otherwise are dropped on open
+	Keys map[string]KeyOptions
 }
 
 // CacheEntry represents a cached prediction
 type CacheEntry struct {
-	ContextHash string
-	Command     string
-	CreatedAt   time.Time
-	HitCount    int
-	LastUsed    time.Time
+	ContextHash string    `json:"context_hash"`
+	Method      string    `json:"method"`
+	Command     string    `json:"command"`
+	CWD         string    `json:"cwd,omitempty"`
+	GitBranch   string    `json:"git_branch,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	HitCount    int       `json:"hit_count"`
+	LastUsed    time.Time `json:"last_used"`
+	// Embedding optionally holds the embedding of the request input, made
+	// with EmbeddingModel, for semantic lookups
+	Embedding      []float32 `json:"-"`
+	EmbeddingModel string    `json:"-"`
 }
 
 // Open opens or creates a SQLite cache database
-func Open(path string) (*Cache, error) {
+func Open(path string, opts Options) (*Cache, error) {
 	// Ensure the directory exists
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, err
 	}
 
-	db, err := sql.Open("sqlite3", path)
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000&_txlock=immediate")
 	if err != nil {
 		return nil, err
 	}
@@ -47,6 +90,23 @@ func Open(path string) (*Cache, error) {
 	);
 
 	CREATE INDEX IF NOT EXISTS idx_last_used ON predictions(last_used);
+
+	CREATE TABLE IF NOT EXISTS suggestions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		method TEXT NOT NULL,
+		provider TEXT NOT NULL,
+		model TEXT NOT NULL,
+		command TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		outcome TEXT,
+		executed TEXT,
+		feedback_at INTEGER
+	);
+
+	CREATE TABLE IF NOT EXISTS meta (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);
 	`
 
 	if _, err := db.Exec(schema); err != nil {
@@ -54,67 +114,213 @@ func Open(path string) (*Cache, error) {
 		return nil, err
 	}
 
-	return &Cache{db: db}, nil
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := dropStaleEntries(db, opts.Keys); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if opts.CleanupInterval <= 0 {
+		opts.CleanupInterval = DefaultCleanupInterval
+	}
+
+	return &Cache{db: db, opts: opts}, nil
+}
+
+// ttl returns the age at which entries of a method expire
+func (o Options) ttl(method string) time.Duration {
+	if ttl, ok := o.MethodTTLs[method]; ok {
+		return ttl
+	}
+	return o.TTL
 }
 
-// Get retrieves a cached entry by context hash
-func (c *Cache) Get(contextHash string) *CacheEntry {
+// expiryCutoff returns the creation time before which entries of a method
+// are expired, or 0 when they never expire
+func (c *Cache) expiryCutoff(method string) int64 {
+	ttl := c.opts.ttl(method)
+	if ttl <= 0 {
+		return 0
+	}
+	return time.Now().Add(-ttl).Unix()
+}
+
+// expired returns the SQL condition matching expired entries of every
+// method and its arguments
+func (c *Cache) expired() (string, []any) {
+	var conds []string
+	var args []any
+
+	var methods []any
+	for method := range c.opts.MethodTTLs {
+		methods = append(methods, method)
+		if cutoff := c.expiryCutoff(method); cutoff > 0 {
+			conds = append(conds, "(method = ? AND created_at < ?)")
+			args = append(args, method, cutoff)
+		}
+	}
+
+	// Methods without their own TTL use the default one
+	if c.opts.TTL > 0 {
+		cond := "created_at < ?"
+		if len(methods) > 0 {
+			placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(methods)), ", ")
+			cond = "(method NOT IN (" + placeholders + ") AND created_at < ?)"
+			args = append(args, methods...)
+		}
+		conds = append(conds, cond)
+		args = append(args, time.Now().Add(-c.opts.TTL).Unix())
+	}
+
+	if len(conds) == 0 {
+		return "0", nil
+	}
+	return strings.Join(conds, " OR "), args
+}
+
+// entryColumns are the columns scanned by scanEntry
+const entryColumns = "context_hash, method, command, cwd, git_branch, created_at, hit_count, last_used"
+
+// scanner is implemented by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanEntry reads a row of entryColumns
+func scanEntry(row scanner) (*CacheEntry, error) {
 	var entry CacheEntry
 	var createdAt, lastUsed int64
 
-	err := c.db.QueryRow(`
-		SELECT context_hash, command, created_at, hit_count, last_used
-		FROM predictions
-		WHERE context_hash = ?
-	`, contextHash).Scan(
+	err := row.Scan(
 		&entry.ContextHash,
+		&entry.Method,
 		&entry.Command,
+		&entry.CWD,
+		&entry.GitBranch,
 		&createdAt,
 		&entry.HitCount,
 		&lastUsed,
 	)
+	if err != nil {
+		return nil, err
+	}
+
+	entry.CreatedAt = time.Unix(createdAt, 0)
+	entry.LastUsed = time.Unix(lastUsed, 0)
+	return &entry, nil
+}
+
+// Get retrieves a cached entry of a method by context hash
+func (c *Cache) Get(method, contextHash string) *CacheEntry {
+	entry, err := scanEntry(c.db.QueryRow(`
+		SELECT `+entryColumns+`
+		FROM predictions
+		WHERE context_hash = ? AND method = ? AND created_at >= ?
+	`, contextHash, method, c.expiryCutoff(method)))
 
 	if err != nil {
+		c.count(missesPrefix + method)
 		return nil
 	}
+	c.count(hitsPrefix + method)
 
-	// Update hit count and last used time
+	c.touch(contextHash)
+	return entry
+}
+
+// touch updates the hit count and last used time of an entry
+func (c *Cache) touch(contextHash string) {
 	c.db.Exec(`
 		UPDATE predictions
 		SET hit_count = hit_count + 1, last_used = ?
 		WHERE context_hash = ?
 	`, time.Now().Unix(), contextHash)
+}
 
-	entry.CreatedAt = time.Unix(createdAt, 0)
-	entry.LastUsed = time.Unix(lastUsed, 0)
-
-	return &entry
+// count increments a counter in the meta table
+func (c *Cache) count(key string) {
+	c.db.Exec(`
+		INSERT INTO meta (key, value) VALUES (?, '1')
+		ON CONFLICT (key) DO UPDATE SET value = CAST(value AS INTEGER) + 1
+	`, key)
 }
 
-// Set stores or updates a cache entry
-func (c *Cache) Set(contextHash, command string) error {
+// Set stores or updates a cache entry. Every CleanupInterval, a write also
+// evicts expired and excess entries.
+func (c *Cache) Set(entry *CacheEntry) error {
 	now := time.Now().Unix()
 
 	_, err := c.db.Exec(`
 		INSERT OR REPLACE INTO predictions
-		(context_hash, command, created_at, hit_count, last_used)
-		VALUES (?, ?, ?, 0, ?)
-	`, contextHash, command, now, now)
+		(context_hash, method, command, cwd, git_branch, created_at, hit_count, last_used,
+			embedding, embedding_model)
+		VALUES (?, ?, ?, ?, ?, ?, 0, ?, ?, ?)
+	`, entry.ContextHash, entry.Method, entry.Command, entry.CWD, entry.GitBranch, now, now,
+		encodeVector(entry.Embedding), entry.EmbeddingModel)
+	if err != nil {
+		return err
+	}
+
+	c.maybeCleanup()
+	return nil
+}
+
+// maybeCleanup evicts entries when the last eviction, by any process, is
+// older than CleanupInterval
+func (c *Cache) maybeCleanup() {
+	now := time.Now()
+
+	var last int64
+	var value string
+	if err := c.db.QueryRow("SELECT value FROM meta WHERE key = ?", lastCleanupKey).Scan(&value); err == nil {
+		last, _ = strconv.ParseInt(value, 10, 64)
+	}
+	if now.Sub(time.Unix(last, 0)) < c.opts.CleanupInterval {
+		return
+	}
 
-	return err
+	// Claim this round first so concurrent writers don't all evict
+	result, err := c.db.Exec(`
+		INSERT INTO meta (key, value) VALUES (?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value
+		WHERE value = ?
+	`, lastCleanupKey, strconv.FormatInt(now.Unix(), 10), value)
+	if err != nil {
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return
+	}
+
+	c.Cleanup()
 }
 
-// Cleanup removes old entries based on TTL and max entries limit
-func (c *Cache) Cleanup(maxAge time.Duration, maxEntries int) error {
+// Cleanup removes expired entries and all but the MaxEntries most recently
+// used ones, as well as old suggestions. It returns the number of entries
+// removed.
+func (c *Cache) Cleanup() (int, error) {
+	removed := 0
+
+	if err := c.pruneSuggestions(); err != nil {
+		return removed, err
+	}
+
 	// Delete expired entries
-	cutoff := time.Now().Add(-maxAge).Unix()
-	if _, err := c.db.Exec("DELETE FROM predictions WHERE last_used < ?", cutoff); err != nil {
-		return err
+	where, args := c.expired()
+	result, err := c.db.Exec("DELETE FROM predictions WHERE "+where, args...)
+	if err != nil {
+		return removed, err
 	}
+	n, _ := result.RowsAffected()
+	removed += int(n)
 
-	// Keep only the most recently used maxEntries
-	if maxEntries > 0 {
-		_, err := c.db.Exec(`
+	// Keep only the most recently used MaxEntries
+	if maxEntries := c.opts.MaxEntries; maxEntries > 0 {
+		result, err := c.db.Exec(`
 			DELETE FROM predictions
 			WHERE context_hash NOT IN (
 				SELECT context_hash FROM predictions
@@ -123,13 +329,32 @@ func (c *Cache) Cleanup(maxAge time.Duration, maxEntries int) error {
 			)
 		`, maxEntries)
 		if err != nil {
-			return err
+			return removed, err
 		}
+		n, _ := result.RowsAffected()
+		removed += int(n)
+	}
+
+	return removed, nil
+}
+
+// Prune evicts expired and excess entries according to the cache options,
+// as well as old suggestions, and reclaims the space they used. It returns
+// the number of entries removed.
+func (c *Cache) Prune() (int, error) {
+	removed, err := c.Cleanup()
+	if err != nil {
+		return removed, err
 	}
 
+	c.db.Exec(`
+		INSERT INTO meta (key, value) VALUES (?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value
+	`, lastCleanupKey, strconv.FormatInt(time.Now().Unix(), 10))
+
 	// Vacuum to reclaim space
-	_, err := c.db.Exec("VACUUM")
-	return err
+	_, err = c.db.Exec("VACUUM")
+	return removed, err
 }
 
 // Stats returns cache statistics
@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// openTest opens a cache in a temporary directory
+func openTest(t *testing.T, opts Options) *Cache {
+	t.Helper()
+	c, err := Open(filepath.Join(t.TempDir(), "cache.db"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestExpiry(t *testing.T) {
+	opts := Options{
+		TTL:        time.Hour,
+		MethodTTLs: map[string]time.Duration{"explain": 3 * time.Hour, "nl2cmd": 10 * time.Minute, "complete": 0},
+	}
+	now := time.Now()
+
+	tests := []struct {
+		method   string
+		age      time.Duration
+		wantLive bool
+	}{
+		{method: "predict", age: time.Minute, wantLive: true},
+		{method: "predict", age: 2 * time.Hour},
+		{method: "explain", age: 2 * time.Hour, wantLive: true},
+		{method: "explain", age: 4 * time.Hour},
+		{method: "nl2cmd", age: 30 * time.Minute},
+		{method: "complete", age: 24 * time.Hour, wantLive: true},
+	}
+
+	c := openTest(t, opts)
+	var entries []CacheEntry
+	wantRemoved := 0
+	for i, tt := range tests {
+		entries = append(entries, CacheEntry{
+			ContextHash: fmt.Sprint(i),
+			Method:      tt.method,
+			Command:     "ls",
+			CreatedAt:   now.Add(-tt.age),
+		})
+		if !tt.wantLive {
+			wantRemoved++
+		}
+	}
+	if _, err := c.Import(entries); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("%s %s old", tt.method, tt.age), func(t *testing.T) {
+			if live := c.Get(tt.method, fmt.Sprint(i)) != nil; live != tt.wantLive {
+				t.Errorf("Get() found entry = %v, want %v", live, tt.wantLive)
+			}
+		})
+	}
+
+	removed, err := c.Cleanup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != wantRemoved {
+		t.Errorf("Cleanup() removed %d entries, want %d", removed, wantRemoved)
+	}
+}
+
+func TestMaybeCleanup(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		// stale is the number of entries created a day ago
+		stale int
+		sets  int
+		// due marks the last eviction as a day old before every write
+		due       bool
+		wantCount int
+	}{
+		{
+			name:      "excess entries evicted on write",
+			opts:      Options{MaxEntries: 2, CleanupInterval: time.Hour},
+			sets:      3,
+			due:       true,
+			wantCount: 2,
+		},
+		{
+			name:      "expired entries evicted on first write",
+			opts:      Options{TTL: time.Hour, CleanupInterval: time.Hour},
+			stale:     2,
+			sets:      1,
+			wantCount: 1,
+		},
+		{
+			name:      "no eviction until the interval passes",
+			opts:      Options{MaxEntries: 2, CleanupInterval: time.Hour},
+			sets:      3,
+			wantCount: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := openTest(t, tt.opts)
+			var stale []CacheEntry
+			for i := 0; i < tt.stale; i++ {
+				stale = append(stale, CacheEntry{
+					ContextHash: fmt.Sprint("stale", i),
+					Command:     "ls",
+					CreatedAt:   time.Now().Add(-24 * time.Hour),
+				})
+			}
+			if _, err := c.Import(stale); err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < tt.sets; i++ {
+				if tt.due {
+					dayAgo := strconv.FormatInt(time.Now().Add(-24*time.Hour).Unix(), 10)
+					if _, err := c.db.Exec("INSERT OR REPLACE INTO meta (key, value) VALUES (?, ?)", lastCleanupKey, dayAgo); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := c.Set(&CacheEntry{ContextHash: fmt.Sprint(i), Method: "predict", Command: "ls"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			total, _, err := c.Stats()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total != tt.wantCount {
+				t.Errorf("%d entries left, want %d", total, tt.wantCount)
+			}
+		})
+	}
+}
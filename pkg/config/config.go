@@ -2,9 +2,11 @@ package config
 
 import (
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -16,27 +18,93 @@ type Config struct {
 	Cache      CacheConfig      `mapstructure:"cache"`
 	Tracking   TrackingConfig   `mapstructure:"tracking"`
 	ZSH        ZSHConfig        `mapstructure:"zsh"`
+	Daemon     DaemonConfig     `mapstructure:"daemon"`
+	Offline    OfflineConfig    `mapstructure:"offline"`
+	Safety     SafetyConfig     `mapstructure:"safety"`
+	// Pricing lists model prices used to compute costs in stats
+	Pricing []ModelPrice `mapstructure:"pricing"`
 }
 
 // LLMConfig contains LLM provider settings
 type LLMConfig struct {
-	DefaultProvider string                    `mapstructure:"default_provider"`
-	Providers       map[string]ProviderConfig `mapstructure:"providers"`
+	DefaultProvider string `mapstructure:"default_provider"`
+	// FallbackProviders are tried in order when the default provider fails
+	FallbackProviders []string                  `mapstructure:"fallback_providers"`
+	Providers         map[string]ProviderConfig `mapstructure:"providers"`
+	// Timeouts bounds each method (predict, complete, nl2cmd, explain, embed)
+	// end to end, across all providers in the chain
+	Timeouts map[string]time.Duration `mapstructure:"timeouts"`
+	// Instructions are added to the system instructions of every method,
+	// e.g. conventions of the team; being the same for every request, they
+	// are part of the prompt prefix providers cache
+	Instructions string `mapstructure:"instructions"`
 }
 
+// DefaultTimeouts are the per-method deadlines used when none is configured.
+// Predictions are requested interactively and must stay snappy, while nl2cmd
+// and explain are explicitly waited for.
+var DefaultTimeouts = map[string]time.Duration{
+	"predict":  5 * time.Second,
+	"complete": 5 * time.Second,
+	"nl2cmd":   30 * time.Second,
+	"explain":  30 * time.Second,
+	"embed":    5 * time.Second,
+}
+
+// Provider types supported in ProviderConfig.Type
+const (
+	ProviderTypeOpenAI    = "openai"
+	ProviderTypeAnthropic = "anthropic"
+	ProviderTypeHistory   = "history"
+)
+
 // ProviderConfig contains settings for a specific LLM provider
 type ProviderConfig struct {
-	BaseURL     string  `mapstructure:"base_url"`
-	APIKey      string  `mapstructure:"api_key"`
-	Model       string  `mapstructure:"model"`
-	MaxTokens   int     `mapstructure:"max_tokens"`
-	Temperature float64 `mapstructure:"temperature"`
+	// Type selects the registered llm.Provider implementation; empty means "openai"
+	Type          string  `mapstructure:"type"`
+	BaseURL       string  `mapstructure:"base_url"`
+	APIKey        string  `mapstructure:"api_key"`
+	Model         string  `mapstructure:"model"`
+	MaxTokens     int     `mapstructure:"max_tokens"`
+	Temperature   float64 `mapstructure:"temperature"`
+	PromptCaching bool    `mapstructure:"prompt_caching"`
+	// Timeout bounds a single call to the provider; zero means no limit
+	Timeout time.Duration `mapstructure:"timeout"`
 }
 
 // PredictionConfig contains prediction behavior settings
 type PredictionConfig struct {
+	// HistoryLength is how many of the last commands requests use as
+	// context, unless their method sets its own
 	HistoryLength   int `mapstructure:"history_length"`
 	MinPrefixLength int `mapstructure:"min_prefix_length"`
+	// MaxLineTokens shortens history lines estimated to take more tokens
+	// than this in prompts; negative keeps them whole
+	MaxLineTokens int `mapstructure:"max_line_tokens"`
+	// Methods overrides HistoryLength per method (predict, complete, nl2cmd,
+	// explain)
+	Methods map[string]MethodPredictionConfig `mapstructure:"methods"`
+}
+
+// MethodPredictionConfig contains the prediction settings of one method;
+// unset fields inherit those of PredictionConfig
+type MethodPredictionConfig struct {
+	HistoryLength *int `mapstructure:"history_length"`
+}
+
+// Defaults of PredictionConfig
+const (
+	DefaultHistoryLength = 10
+	DefaultMaxLineTokens = 200
+)
+
+// DefaultMethodHistoryLengths cap the history of methods that configure no
+// length of their own. Completions, generated commands and explanations
+// depend less on what was run before than predictions do.
+var DefaultMethodHistoryLengths = map[string]int{
+	"complete": 5,
+	"nl2cmd":   3,
+	"explain":  3,
 }
 
 // CacheConfig contains caching settings
@@ -45,17 +113,198 @@ type CacheConfig struct {
 	DBPath     string `mapstructure:"db_path"`
 	TTLDays    int    `mapstructure:"ttl_days"`
 	MaxEntries int    `mapstructure:"max_entries"`
+	// Methods overrides Enabled and TTLDays per method (predict, complete,
+	// nl2cmd)
+	Methods map[string]MethodCacheConfig `mapstructure:"methods"`
+	// Semantic reuses nl2cmd answers for descriptions of similar meaning
+	Semantic SemanticCacheConfig `mapstructure:"semantic"`
+}
+
+// SemanticCacheConfig contains settings of the embedding-based nl2cmd cache
+type SemanticCacheConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Provider names the OpenAI-compatible provider serving embeddings;
+	// empty means the default provider
+	Provider string `mapstructure:"provider"`
+	// Model is the embedding model
+	Model string `mapstructure:"model"`
+	// Threshold is the cosine similarity from which a previous answer is
+	// reused
+	Threshold float64 `mapstructure:"threshold"`
 }
 
+// Defaults of SemanticCacheConfig
+const (
+	DefaultEmbeddingModel    = "text-embedding-3-small"
+	DefaultSemanticThreshold = 0.9
+)
+
+// MethodCacheConfig contains the cache settings of one method; unset fields
+// inherit those of CacheConfig
+type MethodCacheConfig struct {
+	Enabled *bool `mapstructure:"enabled"`
+	TTLDays *int  `mapstructure:"ttl_days"`
+	// Key replaces the default composition of the method's cache keys
+	Key *CacheKeyConfig `mapstructure:"key"`
+}
+
+// CacheKeyConfig selects the parts of a request's context that its cache key
+// covers, besides the prefix or description. Two requests share an answer
+// when the selected parts match.
+type CacheKeyConfig struct {
+	// HistoryLength is how many of the last commands are covered; negative
+	// counts as zero
+	HistoryLength int  `mapstructure:"history_length"`
+	CWD           bool `mapstructure:"cwd"`
+	GitBranch     bool `mapstructure:"git_branch"`
+	OS            bool `mapstructure:"os"`
+}
+
+// DefaultCacheKeys are the key compositions used when a method configures
+// none. Predictions depend on what was just run; completions and generated
+// commands on where they are run.
+var DefaultCacheKeys = map[string]CacheKeyConfig{
+	"predict":  {HistoryLength: 3, CWD: true, GitBranch: true},
+	"complete": {CWD: true, OS: true},
+	"nl2cmd":   {CWD: true, OS: true},
+}
+
+// CachedMethods are the methods whose answers can be cached
+var CachedMethods = []string{"predict", "complete", "nl2cmd"}
+
 // TrackingConfig contains token tracking settings
 type TrackingConfig struct {
-	Enabled bool   `mapstructure:"enabled"`
-	DBPath  string `mapstructure:"db_path"`
+	Enabled bool         `mapstructure:"enabled"`
+	DBPath  string       `mapstructure:"db_path"`
+	Budget  BudgetConfig `mapstructure:"budget"`
+}
+
+// BudgetLimit caps usage over a period; zero fields mean no limit
+type BudgetLimit struct {
+	Tokens int     `mapstructure:"tokens"`
+	Cost   float64 `mapstructure:"cost"`
 }
 
-// ZSHConfig contains ZSH-specific settings
+// BudgetPeriods holds the limits of each period
+type BudgetPeriods struct {
+	Daily   BudgetLimit `mapstructure:"daily"`
+	Monthly BudgetLimit `mapstructure:"monthly"`
+}
+
+// BudgetConfig caps tracked LLM usage overall and, optionally, per provider
+type BudgetConfig struct {
+	BudgetPeriods `mapstructure:",squash"`
+	Providers     map[string]BudgetPeriods `mapstructure:"providers"`
+}
+
+// IsZero reports whether no limit is set
+func (p BudgetPeriods) IsZero() bool {
+	return p == BudgetPeriods{}
+}
+
+// Enabled reports whether any limit is set
+func (b BudgetConfig) Enabled() bool {
+	if !b.BudgetPeriods.IsZero() {
+		return true
+	}
+	for _, periods := range b.Providers {
+		if !periods.IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+// ZSHConfig contains settings of the shell integrations printed by
+// 'llmsh init'
 type ZSHConfig struct {
+	// Keybindings maps the widgets (nl2cmd, predict, explain) to key
+	// sequences in zsh bindkey notation, overriding DefaultKeybindings; an
+	// empty sequence leaves a widget unbound. Despite the zsh key, bash and
+	// fish bind the same keys, converted to their notation. The accept_prediction entry written
+	// by earlier versions is ignored: it bound Tab, which would shadow
+	// completion.
 	Keybindings map[string]string `mapstructure:"keybindings"`
+	// Binary is the path of the llmsh binary the scripts call; empty means
+	// the binary printing them
+	Binary string `mapstructure:"binary"`
+}
+
+// DefaultKeybindings are the key sequences bound to each widget, in bindkey
+// notation
+var DefaultKeybindings = map[string]string{
+	"nl2cmd":  "^[^M",
+	"predict": "^O",
+	"explain": "^[e",
+}
+
+// WidgetKeybindings returns the key sequence bound to each widget, leaving
+// out unbound widgets
+func (c ZSHConfig) WidgetKeybindings() map[string]string {
+	bindings := maps.Clone(DefaultKeybindings)
+	for widget, key := range c.Keybindings {
+		if _, ok := DefaultKeybindings[widget]; ok {
+			bindings[widget] = key
+		}
+	}
+	for widget, key := range bindings {
+		if key == "" {
+			delete(bindings, widget)
+		}
+	}
+	return bindings
+}
+
+// DaemonConfig contains settings for the long-running daemon
+type DaemonConfig struct {
+	SocketPath string `mapstructure:"socket_path"`
+}
+
+// OfflineConfig contains settings for the local history-based predictor
+type OfflineConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	DBPath  string `mapstructure:"db_path"`
+	// FirstPass answers predict and complete from the local model, without
+	// consulting the LLM, when its confidence reaches MinConfidence
+	FirstPass     bool    `mapstructure:"first_pass"`
+	MinConfidence float64 `mapstructure:"min_confidence"`
+}
+
+// SafetyConfig contains settings of the local checks of the commands
+// suggested to the user
+type SafetyConfig struct {
+	// Action is taken on commands rated Level or above: off, warn, confirm
+	// or refuse; off also skips rating commands
+	Action string `mapstructure:"action"`
+	// Level is the risk from which Action is taken: low, medium or high
+	Level string `mapstructure:"level"`
+}
+
+// Values of SafetyConfig.Action
+const (
+	SafetyActionOff     = "off"
+	SafetyActionWarn    = "warn"
+	SafetyActionConfirm = "confirm"
+	SafetyActionRefuse  = "refuse"
+)
+
+// Defaults of SafetyConfig
+const (
+	DefaultSafetyAction = SafetyActionWarn
+	DefaultSafetyLevel  = "high"
+)
+
+// ModelPrice holds the prices of a model in dollars per million tokens.
+// Model matches any model name it prefixes, since APIs report dated model
+// names; an empty Provider matches every provider.
+type ModelPrice struct {
+	Provider    string  `mapstructure:"provider"`
+	Model       string  `mapstructure:"model"`
+	Input       float64 `mapstructure:"input"`
+	Output      float64 `mapstructure:"output"`
+	CachedInput float64 `mapstructure:"cached_input"`
+	// CacheWrite prices prompt cache writes; zero means the input price
+	CacheWrite float64 `mapstructure:"cache_write"`
 }
 
 var globalConfig *Config
@@ -93,18 +342,58 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("read config: %w", err)
 	}
 
+	if err := checkDurations(v); err != nil {
+		return nil, err
+	}
+
 	var cfg Config
 	if err := v.Unmarshal(&cfg); err != nil {
 		return nil, fmt.Errorf("unmarshal config: %w", err)
 	}
 
 	// Expand paths with ~
+	if cfg.Cache.DBPath == "" {
+		cfg.Cache.DBPath = "~/.llmsh/cache.db"
+	}
 	cfg.Cache.DBPath = expandPath(cfg.Cache.DBPath)
+	if cfg.Cache.Semantic.Model == "" {
+		cfg.Cache.Semantic.Model = DefaultEmbeddingModel
+	}
+	if cfg.Cache.Semantic.Threshold == 0 {
+		cfg.Cache.Semantic.Threshold = DefaultSemanticThreshold
+	}
+	if cfg.Tracking.DBPath == "" {
+		cfg.Tracking.DBPath = "~/.llmsh/tokens.db"
+	}
 	cfg.Tracking.DBPath = expandPath(cfg.Tracking.DBPath)
+	if cfg.Daemon.SocketPath == "" {
+		cfg.Daemon.SocketPath = DefaultSocketPath()
+	}
+	cfg.Daemon.SocketPath = expandPath(cfg.Daemon.SocketPath)
+	if cfg.Offline.DBPath == "" {
+		cfg.Offline.DBPath = "~/.llmsh/history.db"
+	}
+	cfg.Offline.DBPath = expandPath(cfg.Offline.DBPath)
+	cfg.ZSH.Binary = expandPath(cfg.ZSH.Binary)
+	if cfg.Prediction.HistoryLength <= 0 {
+		cfg.Prediction.HistoryLength = DefaultHistoryLength
+	}
+	if cfg.Prediction.MaxLineTokens == 0 {
+		cfg.Prediction.MaxLineTokens = DefaultMaxLineTokens
+	}
+	if cfg.Safety.Action == "" {
+		cfg.Safety.Action = DefaultSafetyAction
+	}
+	if cfg.Safety.Level == "" {
+		cfg.Safety.Level = DefaultSafetyLevel
+	}
 
 	// Expand environment variables in API keys
 	for name, provider := range cfg.LLM.Providers {
 		provider.APIKey = os.ExpandEnv(provider.APIKey)
+		if provider.Type == "" {
+			provider.Type = ProviderTypeOpenAI
+		}
 		cfg.LLM.Providers[name] = provider
 	}
 
@@ -121,6 +410,109 @@ func expandPath(path string) string {
 	return path
 }
 
+// DefaultSocketPath returns the default Unix socket path of the daemon
+func DefaultSocketPath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".llmsh", "llmsh.sock")
+}
+
+// ProviderChain returns the default provider followed by the fallback
+// providers, without duplicates
+func (c LLMConfig) ProviderChain() []string {
+	chain := make([]string, 0, 1+len(c.FallbackProviders))
+	seen := make(map[string]bool)
+	for _, name := range append([]string{c.DefaultProvider}, c.FallbackProviders...) {
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		chain = append(chain, name)
+	}
+	return chain
+}
+
+// checkDurations rejects durations given as bare numbers, which would be
+// read as nanoseconds
+func checkDurations(v *viper.Viper) error {
+	var keys []string
+	for method := range v.GetStringMap("llm.timeouts") {
+		keys = append(keys, "llm.timeouts."+method)
+	}
+	for name := range v.GetStringMap("llm.providers") {
+		keys = append(keys, "llm.providers."+name+".timeout")
+	}
+
+	for _, key := range keys {
+		value := v.Get(key)
+		if _, ok := value.(string); ok || value == nil || fmt.Sprint(value) == "0" {
+			continue
+		}
+		return fmt.Errorf("%s: duration %v has no unit, use e.g. %vs or %vms", key, value, value, value)
+	}
+	return nil
+}
+
+// Timeout returns the deadline for a method, or zero if it has none
+func (c LLMConfig) Timeout(method string) time.Duration {
+	if timeout, ok := c.Timeouts[method]; ok {
+		return timeout
+	}
+	return DefaultTimeouts[method]
+}
+
+// MethodHistoryLength returns how many of the last commands a method uses as
+// context
+func (c PredictionConfig) MethodHistoryLength(method string) int {
+	if length := c.Methods[method].HistoryLength; length != nil {
+		return max(*length, 0)
+	}
+	if length, ok := DefaultMethodHistoryLengths[method]; ok {
+		return min(length, c.HistoryLength)
+	}
+	return c.HistoryLength
+}
+
+// ShellHistoryLength returns how many of the last commands the shell
+// integrations send, enough for the method using the most
+func (c PredictionConfig) ShellHistoryLength() int {
+	length := c.HistoryLength
+	for method := range c.Methods {
+		length = max(length, c.MethodHistoryLength(method))
+	}
+	return length
+}
+
+// MethodEnabled reports whether answers of a method are cached
+func (c CacheConfig) MethodEnabled(method string) bool {
+	if !c.Enabled {
+		return false
+	}
+	if enabled := c.Methods[method].Enabled; enabled != nil {
+		return *enabled
+	}
+	return true
+}
+
+// MethodKey returns the composition of a method's cache keys
+func (c CacheConfig) MethodKey(method string) CacheKeyConfig {
+	if key := c.Methods[method].Key; key != nil {
+		composition := *key
+		composition.HistoryLength = max(composition.HistoryLength, 0)
+		return composition
+	}
+	return DefaultCacheKeys[method]
+}
+
+// MethodTTL returns how long answers of a method are cached, or zero if they
+// never expire
+func (c CacheConfig) MethodTTL(method string) time.Duration {
+	days := c.TTLDays
+	if ttlDays := c.Methods[method].TTLDays; ttlDays != nil {
+		days = *ttlDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
 // GetProvider returns the configuration for the specified provider
 func (c *Config) GetProvider(name string) (ProviderConfig, error) {
 	if name == "" {
@@ -134,3 +526,22 @@ func (c *Config) GetProvider(name string) (ProviderConfig, error) {
 
 	return provider, nil
 }
+
+// Price returns the price of a provider's model, preferring the entry with
+// the longest matching model name
+func (c *Config) Price(provider, model string) (ModelPrice, bool) {
+	var best ModelPrice
+	found := false
+	for _, price := range c.Pricing {
+		if price.Provider != "" && !strings.EqualFold(price.Provider, provider) {
+			continue
+		}
+		if !strings.HasPrefix(strings.ToLower(model), strings.ToLower(price.Model)) {
+			continue
+		}
+		if !found || len(price.Model) > len(best.Model) {
+			best, found = price, true
+		}
+	}
+	return best, found
+}
@@ -0,0 +1,65 @@
+package context
+
+import (
+	"unicode/utf8"
+)
+
+// bytesPerToken approximates how many bytes of a shell command make up a
+// token. Tokenizers differ between providers, so lines are measured with this
+// estimate rather than an exact count.
+const bytesPerToken = 4
+
+// truncationMarker replaces the middle of a shortened line
+const truncationMarker = " [...] "
+
+// LastCommands returns the last n commands of a history, oldest first
+func LastCommands(history []string, n int) []string {
+	if n <= 0 {
+		return nil
+	}
+	if len(history) > n {
+		return history[len(history)-n:]
+	}
+	return history
+}
+
+// EstimateTokens estimates how many tokens a string takes in a prompt
+func EstimateTokens(s string) int {
+	return (len(s) + bytesPerToken - 1) / bytesPerToken
+}
+
+// TruncateLines shortens the commands estimated to take more than maxTokens
+// tokens, keeping their start and end, where the program and the files it
+// works on usually are. A maxTokens below one keeps every command whole.
+func TruncateLines(history []string, maxTokens int) []string {
+	if maxTokens <= 0 {
+		return history
+	}
+
+	truncated := make([]string, len(history))
+	for i, command := range history {
+		truncated[i] = truncateLine(command, maxTokens)
+	}
+	return truncated
+}
+
+// truncateLine shortens a command to about maxTokens tokens
+func truncateLine(command string, maxTokens int) string {
+	if EstimateTokens(command) <= maxTokens {
+		return command
+	}
+
+	keep := max(maxTokens*bytesPerToken-len(truncationMarker), 2)
+	head := command[:runeStart(command, keep-keep/2)]
+	tail := command[runeStart(command, len(command)-keep/2):]
+	return head + truncationMarker + tail
+}
+
+// runeStart moves a byte offset of s back to the start of the rune it falls
+// in, so that cutting there leaves valid UTF-8
+func runeStart(s string, i int) int {
+	for i > 0 && i < len(s) && !utf8.RuneStart(s[i]) {
+		i--
+	}
+	return i
+}
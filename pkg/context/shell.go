@@ -0,0 +1,83 @@
+package context
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// GitBranch returns the branch checked out in the git repository containing
+// dir, "HEAD" when it is detached as git rev-parse --abbrev-ref reports it, or
+// "" outside a repository. It reads .git/HEAD rather than running git, which
+// would cost more than the rest of a prediction request.
+func GitBranch(dir string) string {
+	gitDir := findGitDir(dir)
+	if gitDir == "" {
+		return ""
+	}
+
+	head, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return ""
+	}
+
+	ref, ok := strings.CutPrefix(strings.TrimSpace(string(head)), "ref: ")
+	if !ok {
+		return "HEAD"
+	}
+	return strings.TrimPrefix(ref, "refs/heads/")
+}
+
+// findGitDir returns the git directory of the repository containing dir, or
+// "" if there is none. Worktrees and submodules have a .git file pointing to
+// their git directory.
+func findGitDir(dir string) string {
+	for {
+		gitPath := filepath.Join(dir, ".git")
+		if info, err := os.Stat(gitPath); err == nil {
+			if info.IsDir() {
+				return gitPath
+			}
+			data, err := os.ReadFile(gitPath)
+			if err != nil {
+				return ""
+			}
+			gitDir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+			if !ok {
+				return ""
+			}
+			if !filepath.IsAbs(gitDir) {
+				gitDir = filepath.Join(dir, gitDir)
+			}
+			return gitDir
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// osNames maps GOOS values to the kernel names uname -s prints, so that
+// requests built by llmsh match those built by the shell
+var osNames = map[string]string{
+	"darwin":    "Darwin",
+	"linux":     "Linux",
+	"freebsd":   "FreeBSD",
+	"openbsd":   "OpenBSD",
+	"netbsd":    "NetBSD",
+	"dragonfly": "DragonFly",
+	"solaris":   "SunOS",
+	"illumos":   "SunOS",
+}
+
+// OSName returns the name of the operating system as uname -s prints it
+func OSName() string {
+	if name, ok := osNames[runtime.GOOS]; ok {
+		return name
+	}
+	return runtime.GOOS
+}
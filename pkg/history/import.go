@@ -0,0 +1,103 @@
+package history
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// zshExtendedEntry matches the header of a zsh EXTENDED_HISTORY entry,
+// e.g. ": 1700000000:0;git status"
+var zshExtendedEntry = regexp.MustCompile(`^: \d+:\d+;`)
+
+// DefaultHistoryFile returns the history file of the user's shell, based on
+// $HISTFILE and $SHELL
+func DefaultHistoryFile() string {
+	if file := os.Getenv("HISTFILE"); file != "" {
+		return file
+	}
+
+	home, _ := os.UserHomeDir()
+	switch filepath.Base(os.Getenv("SHELL")) {
+	case "bash":
+		return filepath.Join(home, ".bash_history")
+	case "fish":
+		return filepath.Join(home, ".local", "share", "fish", "fish_history")
+	default:
+		return filepath.Join(home, ".zsh_history")
+	}
+}
+
+// ReadHistoryFile reads the commands of a zsh, bash or fish history file in
+// chronological order
+func ReadHistoryFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseHistory(f)
+}
+
+// ParseHistory parses shell history in zsh (plain or extended), bash or fish
+// format
+func ParseHistory(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var commands []string
+	var current strings.Builder
+	continued := false
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if !continued {
+			switch {
+			case strings.HasPrefix(line, "#") && isDigits(line[1:]):
+				// bash HISTTIMEFORMAT timestamp
+				continue
+			case strings.HasPrefix(line, "- cmd: "):
+				// fish history entry
+				line = strings.TrimPrefix(line, "- cmd: ")
+			case strings.HasPrefix(line, "  when: "), strings.HasPrefix(line, "  paths:"), strings.HasPrefix(line, "    - "):
+				// fish entry metadata
+				continue
+			default:
+				line = zshExtendedEntry.ReplaceAllString(line, "")
+			}
+		}
+
+		// zsh writes multi-line commands with a trailing backslash
+		if strings.HasSuffix(line, "\\") {
+			current.WriteString(strings.TrimSuffix(line, "\\"))
+			current.WriteString("\n")
+			continued = true
+			continue
+		}
+
+		current.WriteString(line)
+		commands = append(commands, current.String())
+		current.Reset()
+		continued = false
+	}
+
+	return clean(commands), scanner.Err()
+}
+
+// isDigits reports whether s is a non-empty string of ASCII digits
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
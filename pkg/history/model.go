@@ -0,0 +1,392 @@
+package history
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	// maxOrder is the number of previous commands a transition depends on
+	maxOrder = 2
+	// backoff discounts predictions from shorter contexts
+	backoff = 0.4
+	// scopeBackoff discounts predictions from less specific scopes
+	scopeBackoff = 0.8
+	// tailLength is the number of commands remembered between Learn calls to
+	// find where a new history window overlaps the last one
+	tailLength = 50
+	// maxRows bounds the transitions read for a single context
+	maxRows = 200
+	// sessionRetention is how long the learn cursor of a shell session that
+	// sends no more requests is kept
+	sessionRetention = 30 * 24 * time.Hour
+	// separator joins previous commands into a context key
+	separator = "\x1f"
+)
+
+var (
+	mu     sync.Mutex
+	dbPath string
+	shared *Model
+)
+
+func init() {
+	home, _ := os.UserHomeDir()
+	dbPath = filepath.Join(home, ".llmsh", "history.db")
+}
+
+// SetDBPath sets the database path of the shared model
+func SetDBPath(path string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if path == dbPath {
+		return
+	}
+	if shared != nil {
+		shared.Close()
+		shared = nil
+	}
+	dbPath = path
+}
+
+// Shared returns the model of the database set by SetDBPath, opening it on
+// first use. The offline predictor and the history provider share it, so
+// that a process holds a single connection to the database.
+func Shared() (*Model, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if shared == nil {
+		model, err := Open(dbPath)
+		if err != nil {
+			return nil, err
+		}
+		shared = model
+	}
+	return shared, nil
+}
+
+// Close closes the shared model, if open
+func Close() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if shared == nil {
+		return nil
+	}
+	err := shared.Close()
+	shared = nil
+	return err
+}
+
+// Model is an n-gram (Markov) model over shell command sequences. Every
+// transition is counted in three scopes: globally, per directory and per
+// directory and git branch, so predictions prefer what was done in the same
+// place before.
+type Model struct {
+	db *sql.DB
+}
+
+// Window tells where a history window comes from
+type Window struct {
+	// Session identifies the shell session; the windows of each session are
+	// learned on their own, since concurrent shells interleave requests
+	Session string
+	// End is the history event number of the last command of the window,
+	// or zero when the shell does not send it
+	End int64
+}
+
+// Prediction represents a predicted command
+type Prediction struct {
+	Command    string
+	Confidence float64
+}
+
+// Open opens or creates a model database
+func Open(path string) (*Model, error) {
+	// Ensure the directory exists
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+
+	// Create table schema
+	schema := `
+	CREATE TABLE IF NOT EXISTS transitions (
+		scope TEXT NOT NULL,
+		context TEXT NOT NULL,
+		next TEXT NOT NULL,
+		count INTEGER NOT NULL,
+		last_seen INTEGER NOT NULL,
+		PRIMARY KEY (scope, context, next)
+	);
+
+	CREATE TABLE IF NOT EXISTS learn_cursors (
+		session TEXT PRIMARY KEY,
+		position INTEGER NOT NULL,
+		tail TEXT NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+	`
+
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Model{db: db}, nil
+}
+
+// Close closes the database connection
+func (m *Model) Close() error {
+	return m.db.Close()
+}
+
+// scopes returns the scope keys for a location, most specific first
+func scopes(cwd, gitBranch string) []string {
+	var result []string
+	if cwd != "" && gitBranch != "" {
+		result = append(result, "branch:"+cwd+separator+gitBranch)
+	}
+	if cwd != "" {
+		result = append(result, "dir:"+cwd)
+	}
+	return append(result, "global")
+}
+
+// contexts returns the context keys preceding position i of a sequence,
+// longest first, down to the empty context
+func contexts(sequence []string, i int) []string {
+	var result []string
+	for order := min(maxOrder, i); order > 0; order-- {
+		result = append(result, strings.Join(sequence[i-order:i], separator))
+	}
+	return append(result, "")
+}
+
+// Learn counts the transitions of a history window observed in a directory.
+// Windows sent with consecutive requests of a session overlap, so only the
+// commands after the last event learned from the session are counted. When
+// the window carries no event numbers, or starts a session, the commands
+// following the last learned ones are found by comparing them instead.
+func (m *Model) Learn(history []string, window Window, cwd, gitBranch string) error {
+	history = clean(history)
+	if len(history) == 0 {
+		return nil
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var position int64
+	var tailJSON string
+	var tail []string
+	err = tx.QueryRow("SELECT position, tail FROM learn_cursors WHERE session = ?", window.Session).Scan(&position, &tailJSON)
+	known := err == nil
+	if !known {
+		// A new session usually loaded its history from the same file as
+		// the last one, so it starts where that one ended
+		tx.QueryRow("SELECT tail FROM learn_cursors ORDER BY updated_at DESC LIMIT 1").Scan(&tailJSON)
+	}
+	json.Unmarshal([]byte(tailJSON), &tail)
+
+	var start int
+	switch {
+	case known && window.End > 0 && position > 0 && window.End >= position:
+		start = len(history) - int(min(window.End-position, int64(len(history))))
+	case known && window.End > 0 && position > 0:
+		// The shell numbers its history anew, e.g. after it was cleared
+		start = 0
+	default:
+		start = overlap(tail, history)
+	}
+	if err := countTransitions(tx, history, start, scopes(cwd, gitBranch)); err != nil {
+		return err
+	}
+
+	// Remember the end of the window for the next call
+	tail = append(tail, history[start:]...)
+	if len(tail) > tailLength {
+		tail = tail[len(tail)-tailLength:]
+	}
+	data, _ := json.Marshal(tail)
+	now := time.Now()
+	if _, err := tx.Exec(`
+		INSERT OR REPLACE INTO learn_cursors (session, position, tail, updated_at)
+		VALUES (?, ?, ?, ?)
+	`, window.Session, window.End, string(data), now.Unix()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM learn_cursors WHERE updated_at < ?", now.Add(-sessionRetention).Unix()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Import counts the transitions of a full history, e.g. read from a shell
+// history file. Imported commands carry no location, so they only feed the
+// global scope.
+func (m *Model) Import(commands []string) (int, error) {
+	commands = clean(commands)
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := countTransitions(tx, commands, 0, scopes("", "")); err != nil {
+		return 0, err
+	}
+	return len(commands), tx.Commit()
+}
+
+// countTransitions counts every command of a sequence from position start on
+// under each of its contexts in each scope
+func countTransitions(tx *sql.Tx, sequence []string, start int, scopeKeys []string) error {
+	stmt, err := tx.Prepare(`
+		INSERT INTO transitions (scope, context, next, count, last_seen)
+		VALUES (?, ?, ?, 1, ?)
+		ON CONFLICT (scope, context, next)
+		DO UPDATE SET count = count + 1, last_seen = excluded.last_seen
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now().Unix()
+	for i := start; i < len(sequence); i++ {
+		for _, scope := range scopeKeys {
+			for _, context := range contexts(sequence, i) {
+				if _, err := stmt.Exec(scope, context, sequence[i], now); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Predict returns up to n likely next commands after a history window. With
+// a prefix, only commands completing it are considered. Counts from the
+// longest context and most specific scope dominate; shorter contexts and
+// wider scopes are discounted ("stupid backoff").
+func (m *Model) Predict(history []string, cwd, gitBranch, prefix string, n int) ([]Prediction, error) {
+	history = clean(history)
+	scores := make(map[string]float64)
+
+	contextWeight := 1.0
+	for _, context := range contexts(history, len(history)) {
+		weight := contextWeight
+		for _, scope := range scopes(cwd, gitBranch) {
+			counts, total, err := m.lookup(scope, context, prefix)
+			if err != nil {
+				return nil, err
+			}
+			for command, count := range counts {
+				score := weight * float64(count) / float64(total)
+				if score > scores[command] {
+					scores[command] = score
+				}
+			}
+			weight *= scopeBackoff
+		}
+		contextWeight *= backoff
+	}
+
+	predictions := make([]Prediction, 0, len(scores))
+	for command, score := range scores {
+		predictions = append(predictions, Prediction{Command: command, Confidence: score})
+	}
+	sort.Slice(predictions, func(i, j int) bool {
+		if predictions[i].Confidence != predictions[j].Confidence {
+			return predictions[i].Confidence > predictions[j].Confidence
+		}
+		return predictions[i].Command < predictions[j].Command
+	})
+
+	if n > 0 && len(predictions) > n {
+		predictions = predictions[:n]
+	}
+	return predictions, nil
+}
+
+// lookup returns the counts of commands following a context, restricted to
+// commands extending prefix, and their total count
+func (m *Model) lookup(scope, context, prefix string) (map[string]int, int, error) {
+	var total int
+	err := m.db.QueryRow(`
+		SELECT COALESCE(SUM(count), 0) FROM transitions
+		WHERE scope = ? AND context = ?
+		AND substr(next, 1, length(?)) = ? AND next != ?
+	`, scope, context, prefix, prefix, prefix).Scan(&total)
+	if err != nil || total == 0 {
+		return nil, 0, err
+	}
+
+	rows, err := m.db.Query(`
+		SELECT next, count FROM transitions
+		WHERE scope = ? AND context = ?
+		AND substr(next, 1, length(?)) = ? AND next != ?
+		ORDER BY count DESC
+		LIMIT ?
+	`, scope, context, prefix, prefix, prefix, maxRows)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var next string
+		var count int
+		if err := rows.Scan(&next, &count); err != nil {
+			return nil, 0, err
+		}
+		counts[next] = count
+	}
+	return counts, total, rows.Err()
+}
+
+// overlap returns the position in history where commands not yet seen in
+// tail start, i.e. the length of the longest prefix of history that is a
+// suffix of tail
+func overlap(tail, history []string) int {
+	for k := min(len(tail), len(history)); k > 0; k-- {
+		if slices.Equal(tail[len(tail)-k:], history[:k]) {
+			return k
+		}
+	}
+	return 0
+}
+
+// clean trims commands and drops empty ones
+func clean(commands []string) []string {
+	result := make([]string, 0, len(commands))
+	for _, command := range commands {
+		if command = strings.TrimSpace(command); command != "" {
+			result = append(result, command)
+		}
+	}
+	return result
+}
@@ -0,0 +1,177 @@
+package history
+
+import (
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// openTest opens a model in a temporary directory
+func openTest(t *testing.T) *Model {
+	t.Helper()
+	m, err := Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func TestPredict(t *testing.T) {
+	// Imported commands are only counted in the global scope
+	global := scopeBackoff * scopeBackoff
+
+	tests := []struct {
+		name     string
+		imported []string
+		// learned is a window learned in /p on branch main
+		learned []string
+		history []string
+		prefix  string
+		want    []Prediction
+	}{
+		{
+			name:     "longest context",
+			imported: []string{"a", "b", "c", "x", "b", "d", "a", "b", "c"},
+			history:  []string{"x", "b"},
+			want:     []Prediction{{"d", global}, {"c", 2.0 / 3 * backoff * global}},
+		},
+		{
+			name:     "backoff to a shorter context",
+			imported: []string{"a", "b", "c", "x", "b", "d", "a", "b", "c"},
+			history:  []string{"z", "b"},
+			want:     []Prediction{{"c", 2.0 / 3 * backoff * global}, {"d", 1.0 / 3 * backoff * global}},
+		},
+		{
+			name:     "prefix",
+			imported: []string{"b", "cat x", "b", "cd y", "b", "cat x"},
+			history:  []string{"b"},
+			prefix:   "cd",
+			want:     []Prediction{{"cd y", global}},
+		},
+		{
+			name:     "specific scope first",
+			imported: []string{"make", "make install", "make", "make install"},
+			learned:  []string{"make", "make test"},
+			history:  []string{"make"},
+			want:     []Prediction{{"make test", 1}, {"make install", 2.0 / 3 * global}},
+		},
+		{
+			name:    "unknown history",
+			history: []string{"ls"},
+			want:    []Prediction{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := openTest(t)
+			if _, err := m.Import(tt.imported); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.Learn(tt.learned, Window{Session: "s"}, "/p", "main"); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := m.Predict(tt.history, "/p", "main", tt.prefix, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Predict() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].Command != tt.want[i].Command || math.Abs(got[i].Confidence-tt.want[i].Confidence) > 1e-9 {
+					t.Errorf("Predict() = %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestLearnCursor(t *testing.T) {
+	type step struct {
+		session string
+		end     int64
+		history []string
+	}
+	tests := []struct {
+		name  string
+		steps []step
+		// want counts how often each command was learned
+		want map[string]int
+	}{
+		{
+			name: "overlapping windows",
+			steps: []step{
+				{"s", 3, []string{"a", "b", "c"}},
+				{"s", 4, []string{"b", "c", "d"}},
+				{"s", 4, []string{"b", "c", "d"}},
+			},
+			want: map[string]int{"a": 1, "b": 1, "c": 1, "d": 1},
+		},
+		{
+			name: "repeated commands",
+			steps: []step{
+				{"s", 2, []string{"make", "make"}},
+				{"s", 3, []string{"make", "make", "make"}},
+			},
+			want: map[string]int{"make": 3},
+		},
+		{
+			name: "without event numbers",
+			steps: []step{
+				{"s", 0, []string{"a", "b"}},
+				{"s", 0, []string{"a", "b", "c"}},
+			},
+			want: map[string]int{"a": 1, "b": 1, "c": 1},
+		},
+		{
+			name: "history numbered anew",
+			steps: []step{
+				{"s", 10, []string{"a", "b"}},
+				{"s", 2, []string{"x", "y"}},
+			},
+			want: map[string]int{"a": 1, "b": 1, "x": 1, "y": 1},
+		},
+		{
+			name: "interleaved sessions",
+			steps: []step{
+				{"s1", 2, []string{"a", "b"}},
+				{"s2", 5, []string{"p", "q"}},
+				{"s1", 3, []string{"a", "b", "c"}},
+				{"s2", 6, []string{"p", "q", "r"}},
+			},
+			want: map[string]int{"a": 1, "b": 1, "c": 1, "p": 1, "q": 1, "r": 1},
+		},
+		{
+			name: "new session resuming the shared history",
+			steps: []step{
+				{"s1", 2, []string{"a", "b"}},
+				{"s2", 3, []string{"a", "b", "c"}},
+			},
+			want: map[string]int{"a": 1, "b": 1, "c": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := openTest(t)
+			for _, s := range tt.steps {
+				if err := m.Learn(s.history, Window{Session: s.session, End: s.end}, "", ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, _, err := m.lookup("global", "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("learned %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
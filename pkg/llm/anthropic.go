@@ -0,0 +1,220 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"llmsh/pkg/config"
+	shellctx "llmsh/pkg/context"
+)
+
+const (
+	// anthropicBaseURL is used when the provider has no base_url
+	anthropicBaseURL = "https://api.anthropic.com"
+	// anthropicVersion is the Messages API version sent with every request
+	anthropicVersion = "2023-06-01"
+	// anthropicMaxTokens is used when the provider has no max_tokens, which
+	// the Messages API requires
+	anthropicMaxTokens = 256
+	// anthropicMinCacheTokens is the shortest prompt prefix the API caches;
+	// shorter prefixes marked for caching are processed as usual, without
+	// any cache write or read
+	anthropicMinCacheTokens = 1024
+	// anthropicMinCacheTokensHaiku is the shortest cached prefix of Haiku
+	// models
+	anthropicMinCacheTokensHaiku = 2048
+)
+
+func init() {
+	Register(config.ProviderTypeAnthropic, newAnthropicProvider)
+}
+
+// anthropicProvider calls the native Anthropic Messages API
+type anthropicProvider struct {
+	name   string
+	cfg    config.ProviderConfig
+	client *http.Client
+}
+
+// newAnthropicProvider creates a provider for the Anthropic Messages API
+func newAnthropicProvider(name string, cfg config.ProviderConfig) (Provider, error) {
+	return &anthropicProvider{
+		name:   name,
+		cfg:    cfg,
+		client: &http.Client{},
+	}, nil
+}
+
+// Name returns the provider name from the config
+func (p *anthropicProvider) Name() string {
+	return p.name
+}
+
+// Capabilities returns the optional features the provider supports
+func (p *anthropicProvider) Capabilities() Capabilities {
+	return Capabilities{PromptCaching: p.cfg.PromptCaching}
+}
+
+// anthropicRequest is the body of a Messages API request
+type anthropicRequest struct {
+	Model       string             `json:"model"`
+	MaxTokens   int                `json:"max_tokens"`
+	Temperature *float64           `json:"temperature,omitempty"`
+	System      []anthropicContent `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
+}
+
+// anthropicMessage is a single conversation turn
+type anthropicMessage struct {
+	Role    string             `json:"role"`
+	Content []anthropicContent `json:"content"`
+}
+
+// anthropicContent is a content block of a message
+type anthropicContent struct {
+	Type         string                 `json:"type"`
+	Text         string                 `json:"text"`
+	CacheControl *anthropicCacheControl `json:"cache_control,omitempty"`
+}
+
+// anthropicCacheControl marks the end of a cacheable prompt prefix
+type anthropicCacheControl struct {
+	Type string `json:"type"`
+}
+
+// anthropicResponse is the body of a successful Messages API response
+type anthropicResponse struct {
+	Model   string             `json:"model"`
+	Content []anthropicContent `json:"content"`
+	Usage   struct {
+		InputTokens              int `json:"input_tokens"`
+		OutputTokens             int `json:"output_tokens"`
+		CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+		CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+	} `json:"usage"`
+}
+
+// anthropicError is the body of a failed Messages API response
+type anthropicError struct {
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// anthropicMinCacheTokensFor returns the shortest prompt prefix a model
+// caches
+func anthropicMinCacheTokensFor(model string) int {
+	if strings.Contains(model, "haiku") {
+		return anthropicMinCacheTokensHaiku
+	}
+	return anthropicMinCacheTokens
+}
+
+// Call sends a Messages API request
+func (p *anthropicProvider) Call(ctx context.Context, req *Request) (*Result, error) {
+	cfg := p.cfg
+
+	maxTokens := cfg.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = anthropicMaxTokens
+	}
+	maxTokens = max(maxTokens, req.MaxTokens)
+
+	// The instructions go into the system prompt, the part that stays the
+	// same between requests and can be cached; the context follows in the
+	// user message
+	body := anthropicRequest{
+		Model:     cfg.Model,
+		MaxTokens: maxTokens,
+		Messages: []anthropicMessage{
+			{Role: "user", Content: []anthropicContent{{Type: "text", Text: req.Prompt}}},
+		},
+	}
+	if req.System != "" {
+		system := anthropicContent{Type: "text", Text: req.System}
+		if cfg.PromptCaching && shellctx.EstimateTokens(req.System) >= anthropicMinCacheTokensFor(cfg.Model) {
+			system.CacheControl = &anthropicCacheControl{Type: "ephemeral"}
+		}
+		body.System = []anthropicContent{system}
+	}
+	if cfg.Temperature >= 0 {
+		temperature := cfg.Temperature
+		body.Temperature = &temperature
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("encode request: %w", err)
+	}
+
+	// Accept base URLs with or without the /v1 suffix
+	baseURL := strings.TrimSuffix(cfg.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = anthropicBaseURL
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/v1")
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/v1/messages", bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("anthropic-version", anthropicVersion)
+	if cfg.APIKey != "" {
+		httpReq.Header.Set("x-api-key", cfg.APIKey)
+	}
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("API call failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respData, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr anthropicError
+		if err := json.Unmarshal(respData, &apiErr); err == nil && apiErr.Error.Message != "" {
+			return nil, fmt.Errorf("API call failed: %s: %s", apiErr.Error.Type, apiErr.Error.Message)
+		}
+		return nil, fmt.Errorf("API call failed: %s", resp.Status)
+	}
+
+	var message anthropicResponse
+	if err := json.Unmarshal(respData, &message); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+
+	// Concatenate the text blocks of the reply
+	var sb strings.Builder
+	for _, block := range message.Content {
+		if block.Type == "text" {
+			sb.WriteString(block.Text)
+		}
+	}
+	if sb.Len() == 0 {
+		return nil, ErrEmptyResponse
+	}
+
+	command := removeCodeBlock(sb.String())
+	return &Result{
+		Command: command,
+		Choices: []string{command},
+		Model:   message.Model,
+		Usage: Usage{
+			InputTokens:         message.Usage.InputTokens,
+			OutputTokens:        message.Usage.OutputTokens,
+			CacheCreationTokens: message.Usage.CacheCreationInputTokens,
+			CacheReadTokens:     message.Usage.CacheReadInputTokens,
+		},
+	}, nil
+}
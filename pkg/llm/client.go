@@ -1,23 +1,45 @@
 package llm
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
 	"llmsh/pkg/config"
 )
 
 // Client represents an LLM client
 type Client struct {
 	config config.LLMConfig
+
+	mu        sync.Mutex
+	providers map[string]Provider
+	guard     Guard
 }
 
+// Guard is consulted before each provider call; a non-nil error skips the
+// provider as if the call had failed, e.g. when it is over budget
+type Guard func(provider string) error
+
 // Result represents the result of an LLM call
 type Result struct {
 	Command string
-	Model   string
-	Usage   Usage
+	// Choices holds every sampled command, starting with Command
+	Choices []string
+	// Confidences optionally holds a provider-computed confidence for each
+	// choice, for providers that rank their own answers
+	Confidences []float64
+	Model       string
+	// Provider is the name of the provider that answered
+	Provider string
+	Usage    Usage
 }
 
 // Usage represents token usage information
 type Usage struct {
+	// InputTokens counts input tokens neither written to nor read from the
+	// provider's prompt cache
 	InputTokens         int
 	OutputTokens        int
 	CacheCreationTokens int
@@ -26,41 +48,240 @@ type Usage struct {
 
 // NewClient creates a new LLM client
 func NewClient(cfg config.LLMConfig) *Client {
-	return &Client{config: cfg}
+	return &Client{
+		config:    cfg,
+		providers: make(map[string]Provider),
+	}
+}
+
+// SetGuard installs a guard consulted before each provider call
+func (c *Client) SetGuard(guard Guard) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.guard = guard
 }
 
 // Predict generates a prediction based on context
-func (c *Client) Predict(prompt string) (*Result, error) {
-	provider, err := c.getDefaultProvider()
+func (c *Client) Predict(ctx context.Context, req *Request) (*Result, error) {
+	return c.call(ctx, "predict", req)
+}
+
+// Complete completes a partial command
+func (c *Client) Complete(ctx context.Context, req *Request) (*Result, error) {
+	return c.call(ctx, "complete", req)
+}
+
+// Generate generates a command from natural language
+func (c *Client) Generate(ctx context.Context, req *Request) (*Result, error) {
+	return c.call(ctx, "nl2cmd", req)
+}
+
+// GenerateStream generates a command from natural language like Generate,
+// passing the answer to onDelta piece by piece as it arrives. Providers that
+// cannot stream pass their whole answer at once.
+func (c *Client) GenerateStream(ctx context.Context, req *Request, onDelta func(delta string)) (*Result, error) {
+	req.OnDelta = onDelta
+	return c.call(ctx, "nl2cmd", req)
+}
+
+// Explain describes what a command does. The answer, as requested by the
+// prompt, is returned in Result.Command.
+func (c *Client) Explain(ctx context.Context, req *Request) (*Result, error) {
+	return c.call(ctx, "explain", req)
+}
+
+// call sends a request to the default provider within the method deadline,
+// falling back to the next provider in the chain on error or timeout
+func (c *Client) call(ctx context.Context, method string, req *Request) (*Result, error) {
+	// The caller's request is left as is, so that it can be sent again
+	sent := *req
+	sent.Method = method
+	sent.System = c.systemPrompt(req.System)
+	req = &sent
+
+	chain := c.config.ProviderChain()
+	if len(chain) == 0 {
+		return nil, ErrProviderNotFound
+	}
+
+	if timeout := c.config.Timeout(method); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// Once part of an answer was streamed, no other provider can take over
+	streamed := false
+	if onDelta := req.OnDelta; onDelta != nil {
+		streaming := *req
+		streaming.OnDelta = func(delta string) {
+			streamed = true
+			onDelta(delta)
+		}
+		req = &streaming
+	}
+
+	var errs []error
+	for _, name := range chain {
+		result, err := c.callProvider(ctx, name, req)
+		if err == nil {
+			return result, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", name, err))
+
+		// No point trying fallbacks once the whole call is out of time
+		if ctxErr := contextError(ctx); ctxErr != nil {
+			return nil, fmt.Errorf("%w: %w", ctxErr, errors.Join(errs...))
+		}
+		if streamed {
+			return nil, errs[len(errs)-1]
+		}
+	}
+
+	if len(errs) == 1 {
+		return nil, errs[0]
+	}
+	return nil, fmt.Errorf("all providers failed: %w", errors.Join(errs...))
+}
+
+// systemPrompt assembles the system prompt of a request from the
+// instructions of its method and those of the config. Both are the same for
+// every request of the method, so providers can cache the system prompt.
+func (c *Client) systemPrompt(instructions string) string {
+	switch {
+	case c.config.Instructions == "":
+		return instructions
+	case instructions == "":
+		return c.config.Instructions
+	default:
+		return instructions + "\n\n" + c.config.Instructions
+	}
+}
+
+// contextError maps a finished context to ErrTimeout or ErrCanceled
+func contextError(ctx context.Context) error {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return ErrTimeout
+	case errors.Is(ctx.Err(), context.Canceled):
+		return ErrCanceled
+	default:
+		return nil
+	}
+}
+
+// callProvider sends a request to a single provider within its timeout
+func (c *Client) callProvider(ctx context.Context, name string, req *Request) (*Result, error) {
+	c.mu.Lock()
+	guard := c.guard
+	c.mu.Unlock()
+	if guard != nil {
+		if err := guard(name); err != nil {
+			return nil, err
+		}
+	}
+
+	provider, err := c.Provider(name)
 	if err != nil {
 		return nil, err
 	}
-	return callOpenAICompatible(provider, prompt)
-}
 
-// Complete completes a partial command
-func (c *Client) Complete(prompt string) (*Result, error) {
-	provider, err := c.getDefaultProvider()
+	if timeout := c.config.Providers[name].Timeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var result *Result
+	switch {
+	case req.N > 1 && !provider.Capabilities().MultipleChoices:
+		result, err = callN(ctx, provider, req)
+	case req.N <= 1 && req.OnDelta != nil:
+		result, err = callStream(ctx, provider, req)
+	default:
+		result, err = provider.Call(ctx, req)
+	}
 	if err != nil {
 		return nil, err
 	}
-	return callOpenAICompatible(provider, prompt)
+	result.Provider = name
+	return result, nil
 }
 
-// Generate generates a command from natural language
-func (c *Client) Generate(prompt string) (*Result, error) {
-	provider, err := c.getDefaultProvider()
+// callStream streams the answer of a provider, or passes it whole to
+// req.OnDelta when the provider cannot stream
+func callStream(ctx context.Context, provider Provider, req *Request) (*Result, error) {
+	if streamer, ok := provider.(Streamer); ok {
+		return streamer.Stream(ctx, req, req.OnDelta)
+	}
+
+	result, err := provider.Call(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	return callOpenAICompatible(provider, prompt)
+	req.OnDelta(result.Command)
+	return result, nil
 }
 
-// getDefaultProvider returns the default provider configuration
-func (c *Client) getDefaultProvider() (config.ProviderConfig, error) {
-	provider, ok := c.config.Providers[c.config.DefaultProvider]
+// callN samples req.N alternatives from a provider that returns one per call
+// by making the calls concurrently and merging the results
+func callN(ctx context.Context, provider Provider, req *Request) (*Result, error) {
+	single := *req
+	single.N = 1
+
+	results := make([]*Result, req.N)
+	errs := make([]error, req.N)
+
+	var wg sync.WaitGroup
+	for i := range req.N {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = provider.Call(ctx, &single)
+		}()
+	}
+	wg.Wait()
+
+	// Succeed with whatever calls came back
+	var merged *Result
+	for i, result := range results {
+		if errs[i] != nil {
+			continue
+		}
+		if merged == nil {
+			merged = &Result{Command: result.Command, Model: result.Model}
+		}
+		merged.Choices = append(merged.Choices, result.Choices...)
+		merged.Usage.InputTokens += result.Usage.InputTokens
+		merged.Usage.OutputTokens += result.Usage.OutputTokens
+		merged.Usage.CacheCreationTokens += result.Usage.CacheCreationTokens
+		merged.Usage.CacheReadTokens += result.Usage.CacheReadTokens
+	}
+	if merged == nil {
+		return nil, errors.Join(errs...)
+	}
+	return merged, nil
+}
+
+// Provider returns the named provider, creating it on first use so that
+// its connections are reused across calls
+func (c *Client) Provider(name string) (Provider, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if provider, ok := c.providers[name]; ok {
+		return provider, nil
+	}
+
+	cfg, ok := c.config.Providers[name]
 	if !ok {
-		return config.ProviderConfig{}, ErrProviderNotFound
+		return nil, ErrProviderNotFound
+	}
+
+	provider, err := NewProvider(name, cfg)
+	if err != nil {
+		return nil, err
 	}
+	c.providers[name] = provider
 	return provider, nil
 }
@@ -0,0 +1,65 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+)
+
+// Embedder is implemented by providers that can embed text, e.g. for
+// semantic cache lookups
+type Embedder interface {
+	// Embed returns the embedding of text computed by model
+	Embed(ctx context.Context, model, text string) (*Embedding, error)
+}
+
+// Embedding represents the embedding vector of a text
+type Embedding struct {
+	Vector []float32
+	Model  string
+	// Provider is the name of the provider that answered
+	Provider string
+	Usage    Usage
+}
+
+// Embed embeds text with the named provider, or the default one, within
+// the embed deadline. Providers that cannot embed fail with
+// ErrUnsupportedMethod.
+func (c *Client) Embed(ctx context.Context, name, model, text string) (*Embedding, error) {
+	if name == "" {
+		name = c.config.DefaultProvider
+	}
+
+	c.mu.Lock()
+	guard := c.guard
+	c.mu.Unlock()
+	if guard != nil {
+		if err := guard(name); err != nil {
+			return nil, err
+		}
+	}
+
+	provider, err := c.Provider(name)
+	if err != nil {
+		return nil, err
+	}
+	embedder, ok := provider.(Embedder)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, ErrUnsupportedMethod)
+	}
+
+	if timeout := c.config.Timeout("embed"); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	embedding, err := embedder.Embed(ctx, model, text)
+	if err != nil {
+		if ctxErr := contextError(ctx); ctxErr != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ctxErr, name, err)
+		}
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	embedding.Provider = name
+	return embedding, nil
+}
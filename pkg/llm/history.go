@@ -0,0 +1,76 @@
+package llm
+
+import (
+	"context"
+
+	"llmsh/pkg/config"
+	"llmsh/pkg/history"
+)
+
+// historyModel is the name reported for answers of the history provider
+const historyModel = "ngram"
+
+func init() {
+	Register(config.ProviderTypeHistory, newHistoryProvider)
+}
+
+// historyProvider answers predict and complete requests from the local
+// n-gram model of the shell history, without any network access
+type historyProvider struct {
+	name string
+}
+
+// newHistoryProvider creates a provider backed by the local history model
+func newHistoryProvider(name string, cfg config.ProviderConfig) (Provider, error) {
+	return &historyProvider{name: name}, nil
+}
+
+// Name returns the provider name from the config
+func (p *historyProvider) Name() string {
+	return p.name
+}
+
+// Capabilities returns the optional features the provider supports
+func (p *historyProvider) Capabilities() Capabilities {
+	return Capabilities{MultipleChoices: true}
+}
+
+// Call predicts from the request's structured shell context; the prompt is
+// ignored
+func (p *historyProvider) Call(ctx context.Context, req *Request) (*Result, error) {
+	if req.Method != "predict" && req.Method != "complete" {
+		return nil, ErrUnsupportedMethod
+	}
+	if req.Shell == nil {
+		return nil, ErrEmptyResponse
+	}
+
+	// The model is shared with the offline predictor
+	model, err := history.Shared()
+	if err != nil {
+		return nil, err
+	}
+
+	shell := req.Shell
+	predictions, err := model.Predict(shell.History, shell.CWD, shell.GitBranch, shell.Prefix, max(1, req.N))
+	if err != nil {
+		return nil, err
+	}
+	if len(predictions) == 0 {
+		return nil, ErrEmptyResponse
+	}
+
+	choices := make([]string, 0, len(predictions))
+	confidences := make([]float64, 0, len(predictions))
+	for _, prediction := range predictions {
+		choices = append(choices, prediction.Command)
+		confidences = append(confidences, prediction.Confidence)
+	}
+
+	return &Result{
+		Command:     choices[0],
+		Choices:     choices,
+		Confidences: confidences,
+		Model:       historyModel,
+	}, nil
+}
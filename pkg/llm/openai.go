@@ -2,7 +2,6 @@ package llm
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"strings"
 
@@ -13,15 +12,19 @@ import (
 	"llmsh/pkg/config"
 )
 
-var (
-	// ErrProviderNotFound is returned when a provider is not found
-	ErrProviderNotFound = errors.New("provider not found")
-	// ErrEmptyResponse is returned when the API returns no choices
-	ErrEmptyResponse = errors.New("empty response from API")
-)
+func init() {
+	Register(config.ProviderTypeOpenAI, newOpenAIProvider)
+}
+
+// openAIProvider calls an OpenAI-compatible API endpoint using the official SDK
+type openAIProvider struct {
+	name   string
+	cfg    config.ProviderConfig
+	client openai.Client
+}
 
-// callOpenAICompatible calls an OpenAI-compatible API endpoint using the official SDK
-func callOpenAICompatible(cfg config.ProviderConfig, prompt string) (*Result, error) {
+// newOpenAIProvider creates a provider for an OpenAI-compatible endpoint
+func newOpenAIProvider(name string, cfg config.ProviderConfig) (Provider, error) {
 	// Create client options
 	opts := []option.RequestOption{}
 
@@ -36,46 +39,109 @@ func callOpenAICompatible(cfg config.ProviderConfig, prompt string) (*Result, er
 		opts = append(opts, option.WithBaseURL(baseURL))
 	}
 
-	// Create OpenAI client
-	client := openai.NewClient(opts...)
+	return &openAIProvider{
+		name:   name,
+		cfg:    cfg,
+		client: openai.NewClient(opts...),
+	}, nil
+}
+
+// Name returns the provider name from the config
+func (p *openAIProvider) Name() string {
+	return p.name
+}
+
+// Capabilities returns the optional features the provider supports
+func (p *openAIProvider) Capabilities() Capabilities {
+	// OpenAI caches long prompt prefixes automatically
+	return Capabilities{PromptCaching: true, MultipleChoices: true}
+}
+
+// Call sends a chat completion request
+func (p *openAIProvider) Call(ctx context.Context, req *Request) (*Result, error) {
+	// Make the API call
+	completion, err := p.client.Chat.Completions.New(ctx, p.chatParams(req))
+	if err != nil {
+		return nil, fmt.Errorf("API call failed: %w", err)
+	}
+
+	return newOpenAIResult(completion)
+}
+
+// Stream sends a streaming chat completion request, passing each piece of
+// the answer to onDelta as it arrives
+func (p *openAIProvider) Stream(ctx context.Context, req *Request, onDelta func(delta string)) (*Result, error) {
+	params := p.chatParams(req)
+	// Usage arrives in a last chunk of its own
+	params.StreamOptions = openai.ChatCompletionStreamOptionsParam{
+		IncludeUsage: openai.Bool(true),
+	}
+
+	stream := p.client.Chat.Completions.NewStreaming(ctx, params)
+	defer stream.Close()
+
+	var acc openai.ChatCompletionAccumulator
+	for stream.Next() {
+		chunk := stream.Current()
+		acc.AddChunk(chunk)
+		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			onDelta(chunk.Choices[0].Delta.Content)
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return nil, fmt.Errorf("API call failed: %w", err)
+	}
+
+	return newOpenAIResult(&acc.ChatCompletion)
+}
+
+// chatParams builds the chat completion request for a call
+func (p *openAIProvider) chatParams(req *Request) openai.ChatCompletionNewParams {
+	cfg := p.cfg
 
-	// Prepare chat completion request
+	// Prepare chat completion request; the instructions come first so that
+	// the prompt prefix the API caches covers them
+	var messages []openai.ChatCompletionMessageParamUnion
+	if req.System != "" {
+		messages = append(messages, openai.SystemMessage(req.System))
+	}
+	messages = append(messages, openai.UserMessage(req.Prompt))
 	params := openai.ChatCompletionNewParams{
-		Model: shared.ChatModel(cfg.Model),
-		Messages: []openai.ChatCompletionMessageParamUnion{
-			openai.UserMessage(prompt),
-		},
+		Model:    shared.ChatModel(cfg.Model),
+		Messages: messages,
 	}
 
-	// Set optional parameters
-	if cfg.MaxTokens > 0 {
-		params.MaxTokens = openai.Int(int64(cfg.MaxTokens))
+	// Set optional parameters; the request can only raise max_tokens
+	if maxTokens := max(cfg.MaxTokens, req.MaxTokens); maxTokens > 0 {
+		params.MaxTokens = openai.Int(int64(maxTokens))
 	}
 	if cfg.Temperature >= 0 {
 		params.Temperature = openai.Float(cfg.Temperature)
 	}
-
-	// Make the API call
-	ctx := context.Background()
-	completion, err := client.Chat.Completions.New(ctx, params)
-	if err != nil {
-		return nil, fmt.Errorf("API call failed: %w", err)
+	if req.N > 1 {
+		params.N = openai.Int(int64(req.N))
 	}
 
+	return params
+}
+
+// newOpenAIResult builds a result from a chat completion
+func newOpenAIResult(completion *openai.ChatCompletion) (*Result, error) {
 	// Check if we got any choices
 	if len(completion.Choices) == 0 {
 		return nil, ErrEmptyResponse
 	}
 
-	// Extract the command
-	command := strings.TrimSpace(completion.Choices[0].Message.Content)
-
-	// Remove markdown code blocks if present
-	command = removeCodeBlock(command)
+	// Extract the commands, removing markdown code blocks if present
+	choices := make([]string, 0, len(completion.Choices))
+	for _, choice := range completion.Choices {
+		choices = append(choices, removeCodeBlock(choice.Message.Content))
+	}
 
 	// Build result
 	result := &Result{
-		Command: command,
+		Command: choices[0],
+		Choices: choices,
 		Model:   completion.Model,
 		Usage: Usage{
 			InputTokens:  int(completion.Usage.PromptTokens),
@@ -83,14 +149,43 @@ func callOpenAICompatible(cfg config.ProviderConfig, prompt string) (*Result, er
 		},
 	}
 
-	// Handle cached tokens if available
-	if completion.Usage.PromptTokensDetails.CachedTokens > 0 {
-		result.Usage.CacheReadTokens = int(completion.Usage.PromptTokensDetails.CachedTokens)
+	// Handle cached tokens if available; OpenAI counts them in the prompt
+	// tokens, Usage does not
+	if cached := int(completion.Usage.PromptTokensDetails.CachedTokens); cached > 0 {
+		result.Usage.CacheReadTokens = cached
+		result.Usage.InputTokens -= cached
 	}
 
 	return result, nil
 }
 
+// Embed requests the embedding of text from the embeddings endpoint
+func (p *openAIProvider) Embed(ctx context.Context, model, text string) (*Embedding, error) {
+	response, err := p.client.Embeddings.New(ctx, openai.EmbeddingNewParams{
+		Input:          openai.EmbeddingNewParamsInputUnion{OfString: openai.String(text)},
+		Model:          openai.EmbeddingModel(model),
+		EncodingFormat: openai.EmbeddingNewParamsEncodingFormatFloat,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("API call failed: %w", err)
+	}
+
+	if len(response.Data) == 0 || len(response.Data[0].Embedding) == 0 {
+		return nil, ErrEmptyResponse
+	}
+
+	vector := make([]float32, len(response.Data[0].Embedding))
+	for i, value := range response.Data[0].Embedding {
+		vector[i] = float32(value)
+	}
+
+	return &Embedding{
+		Vector: vector,
+		Model:  response.Model,
+		Usage:  Usage{InputTokens: int(response.Usage.PromptTokens)},
+	}, nil
+}
+
 // removeCodeBlock removes markdown code block markers from the command
 func removeCodeBlock(cmd string) string {
 	// Remove ```bash ... ``` or ```sh ... ``` or just ``` ... ```
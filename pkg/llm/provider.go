@@ -0,0 +1,135 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"llmsh/pkg/config"
+)
+
+var (
+	// ErrProviderNotFound is returned when a provider is not found
+	ErrProviderNotFound = errors.New("provider not found")
+	// ErrEmptyResponse is returned when the API returns no choices
+	ErrEmptyResponse = errors.New("empty response from API")
+	// ErrUnknownProviderType is returned when a provider has an unsupported type
+	ErrUnknownProviderType = errors.New("unknown provider type")
+	// ErrTimeout is returned when a call exceeds its method deadline
+	ErrTimeout = errors.New("request timed out")
+	// ErrCanceled is returned when a call is canceled, e.g. by Ctrl+C
+	ErrCanceled = errors.New("request canceled")
+	// ErrUnsupportedMethod is returned by providers that cannot serve a method
+	ErrUnsupportedMethod = errors.New("method not supported by provider")
+)
+
+// Request represents a single call to a provider
+type Request struct {
+	// Method is the operation being served (predict, complete, nl2cmd,
+	// explain); the client fills it in
+	Method string
+	// System holds the instructions of the method, which stay the same
+	// between requests; providers send them ahead of the prompt, where they
+	// can be cached
+	System string
+	// Prompt holds the context of the request
+	Prompt string
+	// N is the number of alternative commands to sample; zero means one
+	N int
+	// MaxTokens raises the provider's max_tokens for answers longer than a
+	// command
+	MaxTokens int
+	// Shell is the structured context the prompt was built from, for
+	// providers that do not work from prompts
+	Shell *ShellContext
+	// OnDelta, when set, receives the answer piece by piece as it arrives;
+	// it is only used when a single command is asked for
+	OnDelta func(delta string)
+}
+
+// ShellContext describes the shell state a request was made in
+type ShellContext struct {
+	History   []string
+	CWD       string
+	GitBranch string
+	Prefix    string
+}
+
+// Capabilities describes the optional features a provider supports
+type Capabilities struct {
+	// PromptCaching reports whether the provider can reuse cached prompt
+	// prefixes and report cache creation/read tokens
+	PromptCaching bool
+	// MultipleChoices reports whether a single call can return Request.N
+	// alternatives; otherwise the client makes N calls
+	MultipleChoices bool
+}
+
+// Provider is an LLM backend that turns a prompt into a command
+type Provider interface {
+	// Name returns the provider name from the config
+	Name() string
+	// Capabilities returns the optional features the provider supports
+	Capabilities() Capabilities
+	// Call sends a request to the backend
+	Call(ctx context.Context, req *Request) (*Result, error)
+}
+
+// Streamer is implemented by providers that can stream their answer
+type Streamer interface {
+	// Stream sends a request to the backend, passing each piece of the
+	// answer to onDelta as it arrives
+	Stream(ctx context.Context, req *Request, onDelta func(delta string)) (*Result, error)
+}
+
+// Factory creates a provider from its name and configuration
+type Factory func(name string, cfg config.ProviderConfig) (Provider, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a provider type available to NewProvider. It is meant to be
+// called from init functions and panics on duplicate registration.
+func Register(providerType string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, exists := registry[providerType]; exists {
+		panic(fmt.Sprintf("llm: provider type %q registered twice", providerType))
+	}
+	registry[providerType] = factory
+}
+
+// NewProvider creates a provider using the factory registered for its type
+func NewProvider(name string, cfg config.ProviderConfig) (Provider, error) {
+	providerType := cfg.Type
+	if providerType == "" {
+		providerType = config.ProviderTypeOpenAI
+	}
+
+	registryMu.RLock()
+	factory, ok := registry[providerType]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProviderType, providerType)
+	}
+	return factory(name, cfg)
+}
+
+// Types returns the registered provider types in sorted order
+func Types() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	types := make([]string, 0, len(registry))
+	for providerType := range registry {
+		types = append(types, providerType)
+	}
+	sort.Strings(types)
+	return types
+}
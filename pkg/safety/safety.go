@@ -0,0 +1,332 @@
+// Package safety rates how dangerous shell commands are using local rules,
+// independently of what the LLM was asked to generate
+package safety
+
+import (
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Risk levels, from least to most dangerous
+const (
+	Low    = "low"
+	Medium = "medium"
+	High   = "high"
+)
+
+// levels ranks the risk levels
+var levels = map[string]int{Low: 1, Medium: 2, High: 3}
+
+// Valid reports whether a risk level is known
+func Valid(risk string) bool {
+	return levels[risk] > 0
+}
+
+// AtLeast reports whether a risk reaches a level
+func AtLeast(risk, level string) bool {
+	return levels[risk] >= levels[level]
+}
+
+// Max returns the higher of two risk levels
+func Max(a, b string) string {
+	if levels[b] > levels[a] {
+		return b
+	}
+	return a
+}
+
+// Assessment is the rating of a command
+type Assessment struct {
+	Risk string
+	// Reasons describes what the rules flagged; low risk commands have none
+	Reasons []string
+}
+
+// Check rates a command line with the built-in rules; commands no rule flags
+// are low risk
+func Check(command string) *Assessment {
+	calls := parseCalls(command)
+
+	// Only the rules of the highest level flagged give reasons
+	for _, level := range []string{High, Medium} {
+		assessment := &Assessment{Risk: level}
+		for _, r := range rules {
+			if r.risk == level && r.match(command, calls) && !slices.Contains(assessment.Reasons, r.reason) {
+				assessment.Reasons = append(assessment.Reasons, r.reason)
+			}
+		}
+		if len(assessment.Reasons) > 0 {
+			return assessment
+		}
+	}
+	return &Assessment{Risk: Low}
+}
+
+// rule flags commands that match it
+type rule struct {
+	risk   string
+	reason string
+	// match reports whether the rule flags a command line, given the
+	// programs it runs
+	match func(line string, calls []call) bool
+}
+
+// patternRule flags command lines matching a regular expression
+func patternRule(risk, reason, pattern string) rule {
+	re := regexp.MustCompile(pattern)
+	return rule{
+		risk:   risk,
+		reason: reason,
+		match: func(line string, _ []call) bool {
+			return re.MatchString(line)
+		},
+	}
+}
+
+// callRule flags command lines running a program with arguments it matches
+func callRule(risk, reason string, match func(c call) bool) rule {
+	return rule{
+		risk:   risk,
+		reason: reason,
+		match: func(_ string, calls []call) bool {
+			for _, c := range calls {
+				if match(c) {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+var rules = []rule{
+	callRule(High, "recursive delete of a broad path", func(c call) bool {
+		return c.program == "rm" && c.hasFlag("rR", "recursive") && c.anyArg(isBroadPath)
+	}),
+	callRule(High, "rm without root protection", func(c call) bool {
+		return c.program == "rm" && c.hasFlag("", "no-preserve-root")
+	}),
+	callRule(Medium, "forced recursive delete", func(c call) bool {
+		return c.program == "rm" && c.hasFlag("rR", "recursive") && c.hasFlag("f", "force")
+	}),
+	callRule(High, "dd writes to a device", func(c call) bool {
+		return c.program == "dd" && c.anyArg(func(arg string) bool {
+			return strings.HasPrefix(arg, "of=/dev/") && !isHarmlessDevice(strings.TrimPrefix(arg, "of="))
+		})
+	}),
+	callRule(High, "formats or wipes a disk", func(c call) bool {
+		return strings.HasPrefix(c.program, "mkfs") || c.program == "mke2fs" || c.program == "wipefs"
+	}),
+	patternRule(High, "overwrites a block device",
+		`>\s*/dev/(sd|hd|vd|xvd|nvme|mmcblk|disk)\w*`),
+	callRule(High, "recursive permission change on a broad path", func(c call) bool {
+		return (c.program == "chmod" || c.program == "chown" || c.program == "chgrp") &&
+			c.hasFlag("R", "recursive") && c.anyArg(isBroadPath)
+	}),
+	callRule(Medium, "makes files world-writable", func(c call) bool {
+		return c.program == "chmod" && c.anyArg(func(arg string) bool {
+			return arg == "777" || arg == "666" || arg == "a+rwx" || arg == "o+w"
+		})
+	}),
+	callRule(High, "force push rewrites remote history", func(c call) bool {
+		return c.git("push") && (c.hasFlag("f", "force") || c.anyArg(func(arg string) bool {
+			return strings.HasPrefix(arg, "+")
+		}))
+	}),
+	callRule(Medium, "force push with lease", func(c call) bool {
+		return c.git("push") && c.anyArg(func(arg string) bool {
+			return strings.HasPrefix(arg, "--force-with-lease")
+		})
+	}),
+	callRule(Medium, "discards uncommitted changes", func(c call) bool {
+		return (c.git("reset") && c.hasFlag("", "hard")) || (c.git("clean") && c.hasFlag("f", "force"))
+	}),
+	patternRule(High, "runs a downloaded script",
+		`\b(curl|wget)\b[^|;&]*\|\s*(sudo\s+)?(\S*/)?(ba|z|k|da|fi)?sh\b`),
+	patternRule(High, "runs a downloaded script",
+		`\b(ba|z|k|da)?sh\s+<\(\s*(curl|wget)\b`),
+	patternRule(High, "drops a database object",
+		`(?i)\bdrop\s+(table|database|schema)\b`),
+	patternRule(High, "empties a table",
+		`(?i)\btruncate\s+table\b`),
+	patternRule(High, "deletes every row of a table",
+		`(?i)\bdelete\s+from\s+[\w."]+\s*(;|$|["'])`),
+	patternRule(High, "fork bomb",
+		`:\(\)\s*\{\s*:\s*\|\s*:\s*&\s*\}\s*;\s*:`),
+	callRule(High, "moves files to /dev/null", func(c call) bool {
+		return c.program == "mv" && len(c.args) > 0 && c.args[len(c.args)-1] == "/dev/null"
+	}),
+	callRule(Medium, "shuts down or reboots the machine", func(c call) bool {
+		switch c.program {
+		case "shutdown", "reboot", "halt", "poweroff":
+			return true
+		}
+		return false
+	}),
+	callRule(Medium, "kills many processes", func(c call) bool {
+		return c.program == "killall" || c.program == "pkill" ||
+			(c.program == "kill" && c.anyArg(func(arg string) bool { return arg == "-1" }))
+	}),
+	callRule(Medium, "deletes the files it finds", func(c call) bool {
+		return c.program == "find" && c.anyArg(func(arg string) bool {
+			return arg == "-delete"
+		})
+	}),
+}
+
+// broadPaths are paths whose recursive deletion or permission change wrecks a
+// system or home directory
+var broadPaths = map[string]bool{
+	"/": true, "~": true, "$HOME": true, "${HOME}": true, "*": true, ".": true, "..": true,
+	"/bin": true, "/boot": true, "/dev": true, "/etc": true, "/home": true, "/lib": true,
+	"/lib64": true, "/opt": true, "/root": true, "/sbin": true, "/srv": true, "/usr": true,
+	"/var": true, "/System": true, "/Users": true, "/Library": true, "/Applications": true,
+}
+
+// isBroadPath reports whether a path is, or is every file in, a broad path
+func isBroadPath(path string) bool {
+	path = strings.TrimSuffix(path, "/*")
+	if path != "/" {
+		path = strings.TrimSuffix(path, "/")
+	}
+	if path == "" {
+		path = "/"
+	}
+	return broadPaths[path]
+}
+
+// isHarmlessDevice reports whether writing to a device loses no data
+func isHarmlessDevice(path string) bool {
+	switch path {
+	case "/dev/null", "/dev/zero", "/dev/stdout", "/dev/stderr", "/dev/tty":
+		return true
+	}
+	return false
+}
+
+// call is one program run by a command line
+type call struct {
+	program string
+	args    []string
+}
+
+// hasFlag reports whether the call has one of the short flags, possibly
+// combined with others as in -rf, or the long flag; empty disables either
+func (c call) hasFlag(shorts, long string) bool {
+	for _, arg := range c.args {
+		switch {
+		case arg == "--":
+			return false
+		case strings.HasPrefix(arg, "--"):
+			if long != "" && arg == "--"+long {
+				return true
+			}
+		case strings.HasPrefix(arg, "-"):
+			if shorts != "" && strings.ContainsAny(arg[1:], shorts) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// anyArg reports whether any argument matches
+func (c call) anyArg(match func(arg string) bool) bool {
+	for _, arg := range c.args {
+		if match(arg) {
+			return true
+		}
+	}
+	return false
+}
+
+// gitValueOptions are the git options given before the subcommand that take
+// the next word as their value
+var gitValueOptions = map[string]bool{
+	"-C": true, "-c": true, "--git-dir": true, "--work-tree": true, "--namespace": true,
+	"--super-prefix": true, "--config-env": true,
+}
+
+// git reports whether the call runs a git subcommand, skipping the options
+// given to git itself, as in git -C dir push
+func (c call) git(subcommand string) bool {
+	if c.program != "git" {
+		return false
+	}
+	for i := 0; i < len(c.args); i++ {
+		arg := c.args[i]
+		switch {
+		case gitValueOptions[arg]:
+			i++
+		case strings.HasPrefix(arg, "-"):
+		default:
+			return arg == subcommand
+		}
+	}
+	return false
+}
+
+// separators split a command line into the commands it runs
+var separators = regexp.MustCompile("&&|\\|\\||[;&|\n()`]|\\$\\(")
+
+// wrappers are programs that run the command following them, along with
+// their options that take the next word as their value, as in sudo -u root
+var wrappers = map[string][]string{
+	"sudo": {"-u", "--user", "-g", "--group", "-C", "--close-from", "-D", "--chdir",
+		"-h", "--host", "-p", "--prompt", "-r", "--role", "-t", "--type",
+		"-T", "--command-timeout", "-U", "--other-user"},
+	"doas":    {"-u", "-C"},
+	"env":     {"-u", "--unset", "-C", "--chdir"},
+	"command": nil,
+	"exec":    {"-a"},
+	"nohup":   nil,
+	"time":    {"-f", "--format", "-o", "--output"},
+	"nice":    {"-n", "--adjustment"},
+	"xargs": {"-a", "--arg-file", "-d", "--delimiter", "-E", "-I", "-L", "--max-lines",
+		"-n", "--max-args", "-P", "--max-procs", "-s", "--max-chars"},
+}
+
+// parseCalls splits a command line into the programs it runs. Quoting is
+// only undone word by word, which is enough to spot dangerous commands.
+func parseCalls(line string) []call {
+	var calls []call
+	for _, segment := range separators.Split(line, -1) {
+		words := strings.Fields(segment)
+		for i := range words {
+			words[i] = strings.Trim(words[i], `"'`)
+		}
+
+		// Skip wrappers, their flags along with the values they take, and
+		// variable assignments
+		var valueOptions []string
+		for len(words) > 0 {
+			word := words[0]
+			if options, ok := wrappers[filepath.Base(word)]; ok {
+				valueOptions = options
+				words = words[1:]
+				continue
+			}
+			if strings.HasPrefix(word, "-") {
+				words = words[1:]
+				if slices.Contains(valueOptions, word) && len(words) > 0 {
+					words = words[1:]
+				}
+				continue
+			}
+			if strings.Contains(word, "=") && !strings.HasPrefix(word, "=") {
+				words = words[1:]
+				continue
+			}
+			break
+		}
+		if len(words) == 0 {
+			continue
+		}
+
+		program := filepath.Base(strings.TrimPrefix(words[0], `\`))
+		calls = append(calls, call{program: program, args: words[1:]})
+	}
+	return calls
+}
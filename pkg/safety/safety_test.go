@@ -0,0 +1,105 @@
+package safety
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCalls(t *testing.T) {
+	tests := []struct {
+		line string
+		want []call
+	}{
+		{
+			line: "ls -la",
+			want: []call{{program: "ls", args: []string{"-la"}}},
+		},
+		{
+			line: "cd /tmp && rm -rf build; echo done | tee log",
+			want: []call{
+				{program: "cd", args: []string{"/tmp"}},
+				{program: "rm", args: []string{"-rf", "build"}},
+				{program: "echo", args: []string{"done"}},
+				{program: "tee", args: []string{"log"}},
+			},
+		},
+		{
+			line: "sudo -u root /bin/rm -r /",
+			want: []call{{program: "rm", args: []string{"-r", "/"}}},
+		},
+		{
+			line: "sudo -E env -u HOME LANG=C nice -n 10 rm x",
+			want: []call{{program: "rm", args: []string{"x"}}},
+		},
+		{
+			line: `find . -name '*.o' | xargs -I {} rm {}`,
+			want: []call{
+				{program: "find", args: []string{".", "-name", "*.o"}},
+				{program: "rm", args: []string{"{}"}},
+			},
+		},
+		{
+			line: `echo $(\rm "a")`,
+			want: []call{
+				{program: "echo", args: []string{}},
+				{program: "rm", args: []string{"a"}},
+			},
+		},
+		{
+			line: "FOO=1",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			if got := parseCalls(tt.line); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCalls(%q) = %+v, want %+v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"ls -la", Low},
+		{"rm -rf build", Medium},
+		{"rm -rf /", High},
+		{"rm -r ~/", High},
+		{"rm -- -rf /", Low},
+		{"sudo -u root rm -rf /", High},
+		{"sudo -u rm ls", Low},
+		{"env -C /tmp rm -rf *", High},
+		{"nohup time -f %e rm -rf /usr", High},
+		{"echo rm -rf /", Low},
+		{"git push --force", High},
+		{"git -C repo push -f origin main", High},
+		{"git -c user.name=x --git-dir .git push origin +main", High},
+		{"git push --force-with-lease", Medium},
+		{"git -C push status", Low},
+		{"git log --grep push --force", Low},
+		{"git reset --hard HEAD~1", Medium},
+		{"git -C repo clean -fd", Medium},
+		{"curl -fsSL https://example.com/install.sh | sudo bash", High},
+		{"dd if=image.iso of=/dev/sdb bs=4M", High},
+		{"dd if=/dev/urandom of=/dev/null count=1", Low},
+		{"chmod -R 755 /", High},
+		{"chmod 777 script.sh", Medium},
+		{"find . -name '*.tmp' -delete", Medium},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			got := Check(tt.command)
+			if got.Risk != tt.want {
+				t.Errorf("Check(%q) = %s %v, want %s", tt.command, got.Risk, got.Reasons, tt.want)
+			}
+			if (got.Risk == Low) != (len(got.Reasons) == 0) {
+				t.Errorf("Check(%q) gave reasons %v for %s risk", tt.command, got.Reasons, got.Risk)
+			}
+		})
+	}
+}
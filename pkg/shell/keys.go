@@ -0,0 +1,162 @@
+package shell
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parseBindkey decodes a key sequence in zsh bindkey notation into the
+// characters the terminal sends: ^X is Ctrl+X and ^? Backspace, \e and ^[
+// are Escape, \C-x is Ctrl+X, and \M-x is Escape followed by x, as terminals
+// send Alt+X. The escapes of print (\n, \t, \NNN, \xHH...) are understood,
+// and any other escaped character stands for itself.
+func parseBindkey(seq string) (string, error) {
+	var keys strings.Builder
+	for i := 0; i < len(seq); i++ {
+		c := seq[i]
+		switch {
+		case c == '^' && i+1 < len(seq):
+			i++
+			keys.WriteByte(control(seq[i]))
+
+		case c == '\\' && i+1 < len(seq):
+			i++
+			switch e := seq[i]; e {
+			case 'e', 'E':
+				keys.WriteByte('\x1b')
+			case 'a':
+				keys.WriteByte('\a')
+			case 'b':
+				keys.WriteByte('\b')
+			case 'f':
+				keys.WriteByte('\f')
+			case 'n':
+				keys.WriteByte('\n')
+			case 'r':
+				keys.WriteByte('\r')
+			case 't':
+				keys.WriteByte('\t')
+			case 'v':
+				keys.WriteByte('\v')
+			case 'C', 'M':
+				if i+2 >= len(seq) || seq[i+1] != '-' {
+					return "", fmt.Errorf("invalid key sequence %q: \\%c- must be followed by a key", seq, e)
+				}
+				i += 2
+				if e == 'C' {
+					keys.WriteByte(control(seq[i]))
+				} else {
+					keys.WriteByte('\x1b')
+					keys.WriteByte(seq[i])
+				}
+			case 'x':
+				n := digits(seq[i+1:], 2, 16)
+				if n == 0 {
+					keys.WriteByte(e)
+					break
+				}
+				value, _ := strconv.ParseUint(seq[i+1:i+1+n], 16, 8)
+				keys.WriteByte(byte(value))
+				i += n
+			default:
+				if n := digits(seq[i:], 3, 8); n > 0 {
+					value, _ := strconv.ParseUint(seq[i:i+n], 8, 8)
+					keys.WriteByte(byte(value))
+					i += n - 1
+				} else {
+					keys.WriteByte(e)
+				}
+			}
+
+		default:
+			keys.WriteByte(c)
+		}
+	}
+	return keys.String(), nil
+}
+
+// control returns the character Ctrl and a key produce; Ctrl+? is Backspace
+func control(key byte) byte {
+	if key == '?' {
+		return 0x7f
+	}
+	return key & 0x1f
+}
+
+// digits returns how many of the first characters of s, at most limit, are
+// digits in base
+func digits(s string, limit, base int) int {
+	n := 0
+	for n < len(s) && n < limit {
+		if _, err := strconv.ParseUint(s[n:n+1], base, 8); err != nil {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// bashKey converts a key sequence in bindkey notation into a double-quoted
+// readline key sequence, which can itself be single-quoted for bind -x
+func bashKey(seq string) (string, error) {
+	keys, err := parseBindkey(seq)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(keys); i++ {
+		c := keys[i]
+		switch {
+		case c == '\x1b':
+			b.WriteString(`\e`)
+		case c >= 0x01 && c <= 0x1a:
+			b.WriteString(`\C-` + string(rune('a'+c-1)))
+		case c == '"' || c == '\\':
+			b.WriteString(`\` + string(c))
+		case c < 0x20 || c >= 0x7f || c == '\'':
+			fmt.Fprintf(&b, `\%03o`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String(), nil
+}
+
+// fishKey converts a key sequence in bindkey notation into an argument of
+// fish's bind, written with escapes alone
+func fishKey(seq string) (string, error) {
+	keys, err := parseBindkey(seq)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(keys); {
+		r, size := utf8.DecodeRuneInString(keys[i:])
+		i += size
+		switch {
+		case r == '\x1b':
+			b.WriteString(`\e`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r >= 0x01 && r <= 0x1a:
+			b.WriteString(`\c` + string('a'+r-1))
+		case r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r < 0x80:
+			fmt.Fprintf(&b, `\x%02x`, r)
+		case r <= 0xffff:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			fmt.Fprintf(&b, `\U%08x`, r)
+		}
+	}
+	return b.String(), nil
+}
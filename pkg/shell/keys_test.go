@@ -0,0 +1,42 @@
+package shell
+
+import "testing"
+
+func TestKeys(t *testing.T) {
+	tests := []struct {
+		bindkey string
+		bash    string
+		fish    string
+	}{
+		{bindkey: "^[^M", bash: `"\e\C-m"`, fish: `\e\r`},
+		{bindkey: "^O", bash: `"\C-o"`, fish: `\co`},
+		{bindkey: "^[e", bash: `"\ee"`, fish: `\ee`},
+		{bindkey: `\ee`, bash: `"\ee"`, fish: `\ee`},
+		{bindkey: `\M-e`, bash: `"\ee"`, fish: `\ee`},
+		{bindkey: `\C-x\C-e`, bash: `"\C-x\C-e"`, fish: `\cx\ce`},
+		{bindkey: "^?", bash: `"\177"`, fish: `\x7f`},
+		{bindkey: `\x1b[A`, bash: `"\e[A"`, fish: `\e\x5bA`},
+		{bindkey: `\033q`, bash: `"\eq"`, fish: `\eq`},
+		{bindkey: `\M-'`, bash: `"\e\047"`, fish: `\e\x27`},
+		{bindkey: `^G"\\`, bash: `"\C-g\"\\"`, fish: `\cg\x22\x5c`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.bindkey, func(t *testing.T) {
+			if got, err := bashKey(tt.bindkey); err != nil || got != tt.bash {
+				t.Errorf("bashKey(%q) = %q, %v, want %q", tt.bindkey, got, err, tt.bash)
+			}
+			if got, err := fishKey(tt.bindkey); err != nil || got != tt.fish {
+				t.Errorf("fishKey(%q) = %q, %v, want %q", tt.bindkey, got, err, tt.fish)
+			}
+		})
+	}
+}
+
+func TestKeysInvalid(t *testing.T) {
+	for _, bindkey := range []string{`\C-`, `\M`, `^[\C`} {
+		if got, err := bashKey(bindkey); err == nil {
+			t.Errorf("bashKey(%q) = %q, want an error", bindkey, got)
+		}
+	}
+}
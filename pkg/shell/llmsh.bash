@@ -0,0 +1,381 @@
+# llmsh bash integration
+# Provides natural language to command conversion, manual command prediction
+# and command explanation. Load it from ~/.bashrc with:
+#
+#   eval "$(llmsh init bash)"
+
+# ============================================================================
+# Configuration and Initialization
+# ============================================================================
+
+# Binary path, defaulting to the binary that printed this script
+LLMSH_BINARY=${LLMSH_BINARY:-{{quote .Binary}}}
+
+# Number of alternatives fetched when a widget is pressed again
+LLMSH_CANDIDATES="${LLMSH_CANDIDATES:-5}"
+
+# Candidate cycling state shared by the widgets
+_llmsh_candidates=()
+_llmsh_candidate_risks=()
+_llmsh_candidate_index=0
+_llmsh_candidate_widget=""
+_llmsh_candidate_method=""
+_llmsh_candidate_input=""
+
+# Suggestion awaiting feedback: its ID, the command shown last and the
+# history entry that was last when it was shown
+_llmsh_suggestion_id=""
+_llmsh_suggestion=""
+_llmsh_suggestion_histnum=""
+
+# Identifies this shell, whose history the offline predictor learns on its own
+_llmsh_session="$$-$RANDOM"
+
+# Check that the binary is available. The script is evaluated from ~/.bashrc,
+# so it must not return early itself.
+_llmsh_check_dependencies() {
+    if [[ ! -x "$LLMSH_BINARY" ]]; then
+        echo "llmsh: binary not found at $LLMSH_BINARY" >&2
+        echo "llmsh: Please run 'make install' to install the binary" >&2
+        return 1
+    fi
+}
+
+# ============================================================================
+# Helper Functions
+# ============================================================================
+
+# Show a message above the prompt; readline redraws the line after a widget
+_llmsh_message() {
+    printf '\n%s\n' "$1" >&2
+}
+
+# Call llmsh with the input of a method (the prefix, description or command)
+# and additional flags. The request is passed through the environment, and
+# llmsh adds the working directory, git branch and OS; a running daemon
+# serves it. The response is printed as typeset statements, which declare
+# the llmsh_* variables local to the function evaluating them.
+_llmsh_call_binary() {
+    local method="$1"
+    local input="$2"
+    shift 2
+
+    local -x LLMSH_PREFIX="" LLMSH_DESCRIPTION="" LLMSH_COMMAND=""
+    case "$method" in
+        complete) LLMSH_PREFIX="$input" ;;
+        nl2cmd)   LLMSH_DESCRIPTION="$input" ;;
+        explain)  LLMSH_COMMAND="$input" ;;
+    esac
+
+    # Recent history, oldest first, separated by NUL bytes since commands
+    # may span several lines. Entries are expanded one at a time, by event
+    # number, so that they can be told apart.
+    local -x LLMSH_SESSION="$_llmsh_session" LLMSH_HISTORY_END
+    LLMSH_HISTORY_END=$(_llmsh_histnum)
+    local -a entries=()
+    local i entry bang="${histchars:0:1}"
+    for (( i = LLMSH_HISTORY_END - {{.HistoryLength}} + 1; i <= LLMSH_HISTORY_END; i++ )); do
+        (( i > 0 )) || continue
+        entry="$(history -p "${bang:-!}$i" 2>/dev/null)" && entries+=("$entry")
+    done
+
+    printf '%s\0' "${entries[@]}" |
+        LLMSH_HISTORY_FILE=- "$LLMSH_BINARY" "$method" --input env --output sh "$@" 2>/dev/null
+}
+
+# Show the safety warning of the evaluated response, and ask before its
+# command is inserted when confirmation is required. Returns 1 when the user
+# declines.
+_llmsh_check_risk() {
+    [[ -n "$llmsh_action" ]] || return 0
+
+    local reasons="" reason
+    for reason in "${llmsh_risk_reasons[@]}"; do
+        reasons+="${reasons:+, }$reason"
+    done
+    local warning="llmsh: $llmsh_risk risk: $reasons"
+    if [[ "$llmsh_action" != "confirm" ]]; then
+        _llmsh_message "$warning"
+        return 0
+    fi
+
+    local key
+    printf '\n%s. Insert anyway? [y/N] ' "$warning" >&2
+    read -r -n 1 key < /dev/tty
+    printf '\n' >&2
+    [[ "$key" == [yY] ]]
+}
+
+# Remember the candidates of the evaluated response for cycling; the first
+# is shown first
+_llmsh_set_candidates() {
+    local widget="$1"
+    local method="$2"
+    local input="$3"
+
+    _llmsh_candidates=("${llmsh_candidates[@]}")
+    _llmsh_candidate_risks=("${llmsh_candidate_risks[@]}")
+    _llmsh_candidate_index=0
+    _llmsh_candidate_widget="$widget"
+    _llmsh_candidate_method="$method"
+    _llmsh_candidate_input="$input"
+}
+
+# Cycle to the next candidate when a widget is pressed again on the command
+# it inserted. The first press only fetches a single command, so the second
+# one fetches alternatives for the same request. Returns 1 when the widget
+# should run a fresh request instead.
+_llmsh_cycle_candidates() {
+    local widget="$1"
+
+    (( ${#_llmsh_candidates[@]} > 0 )) || return 1
+    [[ "$_llmsh_candidate_widget" == "$widget" ]] || return 1
+    [[ "$READLINE_LINE" == "${_llmsh_candidates[_llmsh_candidate_index]}" ]] || return 1
+
+    if (( ${#_llmsh_candidates[@]} == 1 )); then
+        local method="$_llmsh_candidate_method"
+        local input="$_llmsh_candidate_input"
+        local current="$READLINE_LINE"
+
+        eval "$(_llmsh_call_binary "$method" "$input" --candidates "$LLMSH_CANDIDATES")"
+        _llmsh_set_candidates "$widget" "$method" "$input"
+
+        # Continue after the command already shown, keeping it in the cycle
+        local i index=-1
+        for i in "${!_llmsh_candidates[@]}"; do
+            if [[ "${_llmsh_candidates[i]}" == "$current" ]]; then
+                index=$i
+                break
+            fi
+        done
+        if (( index < 0 )); then
+            _llmsh_candidates=("$current" "${_llmsh_candidates[@]}")
+            _llmsh_candidate_risks=("-" "${_llmsh_candidate_risks[@]}")
+            index=0
+        fi
+        _llmsh_candidate_index=$index
+    fi
+
+    if (( ${#_llmsh_candidates[@]} <= 1 )); then
+        _llmsh_message "llmsh: no alternatives"
+        return 0
+    fi
+
+    (( _llmsh_candidate_index = (_llmsh_candidate_index + 1) % ${#_llmsh_candidates[@]} ))
+    READLINE_LINE="${_llmsh_candidates[_llmsh_candidate_index]}"
+    READLINE_POINT=${#READLINE_LINE}
+    # Feedback refers to the first suggestion and reports the candidate chosen
+    [[ -n "$_llmsh_suggestion_id" ]] && _llmsh_suggestion="$READLINE_LINE"
+
+    # Messages stay in the scrollback in bash, so only flagged ones are shown
+    local risk="${_llmsh_candidate_risks[_llmsh_candidate_index]}"
+    if [[ -n "$risk" && "$risk" != "-" ]]; then
+        _llmsh_message "llmsh: candidate $(( _llmsh_candidate_index + 1 ))/${#_llmsh_candidates[@]} ($risk risk)"
+    fi
+    return 0
+}
+
+# Report what became of the pending suggestion, given the command line that
+# was executed (empty when it was replaced). Runs in the background so the
+# prompt never waits for it.
+_llmsh_send_feedback() {
+    local executed="$1"
+
+    [[ -n "$_llmsh_suggestion_id" ]] || return 0
+
+    ( LLMSH_SUGGESTION_ID="$_llmsh_suggestion_id" \
+        LLMSH_SUGGESTION="$_llmsh_suggestion" \
+        LLMSH_EXECUTED="$executed" \
+        "$LLMSH_BINARY" feedback --input env > /dev/null 2>&1 & )
+
+    _llmsh_suggestion_id=""
+    _llmsh_suggestion=""
+}
+
+# Print the number of the last history entry
+_llmsh_histnum() {
+    local entry
+    entry=$(HISTTIMEFORMAT= history 1)
+    [[ "$entry" =~ ^[[:space:]]*([0-9]+) ]] && echo "${BASH_REMATCH[1]}"
+}
+
+# Start tracking the suggestion of the evaluated response; a suggestion still
+# pending is replaced, so it counts as discarded
+_llmsh_track_suggestion() {
+    local command="$1"
+
+    _llmsh_send_feedback ""
+
+    [[ -n "$command" ]] || return 0
+    _llmsh_suggestion_id="$llmsh_suggestion_id"
+    _llmsh_suggestion="$command"
+    _llmsh_suggestion_histnum=$(_llmsh_histnum)
+}
+
+# PROMPT_COMMAND hook: once a command line was executed after the suggestion
+# was shown, compare it with the suggestion. Bash has no preexec hook, so the
+# last history entry tells what ran. The exit status of the command line is
+# returned unchanged for the prompt and the hooks after this one.
+_llmsh_prompt_command() {
+    local status=$?
+    [[ -n "$_llmsh_suggestion_id" ]] || return $status
+
+    local entry
+    entry=$(HISTTIMEFORMAT= history 1)
+    [[ "$entry" =~ ^[[:space:]]*([0-9]+)[*[:space:]]+(.*)$ ]] || return $status
+    [[ "${BASH_REMATCH[1]}" != "$_llmsh_suggestion_histnum" ]] || return $status
+
+    _llmsh_send_feedback "${BASH_REMATCH[2]}"
+    return $status
+}
+
+# ============================================================================
+# Natural Language to Command Widget
+# ============================================================================
+
+_llmsh_nl2cmd_widget() {
+    # Pressed again: cycle through alternative commands
+    _llmsh_cycle_candidates "nl2cmd" && return
+
+    # The current line is the natural language description
+    local description="$READLINE_LINE"
+
+    # Don't proceed if the line is empty
+    if [[ -z "$description" ]]; then
+        return
+    fi
+
+    # Call nl2cmd
+    eval "$(_llmsh_call_binary "nl2cmd" "$description")"
+    local command="$llmsh_command"
+
+    # Ask before inserting a risky command, if configured to
+    local declined=0
+    if [[ -n "$command" ]] && ! _llmsh_check_risk; then
+        command=""
+        declined=1
+    fi
+    _llmsh_set_candidates "nl2cmd" "nl2cmd" "$description"
+    _llmsh_track_suggestion "$command"
+
+    if [[ -n "$command" ]]; then
+        # Replace the description with the generated command
+        READLINE_LINE="$command"
+        READLINE_POINT=${#READLINE_LINE}
+        return
+    fi
+
+    # Keep the description and report the error, if it was not declined
+    (( declined )) && return
+    case "$llmsh_error_code" in
+        timeout)         _llmsh_message "llmsh: timed out" ;;
+        canceled)        ;;
+        budget_exceeded) _llmsh_message "llmsh: budget exceeded, LLM calls are paused" ;;
+        unsafe_command)  _llmsh_message "llmsh: refused unsafe command" ;;
+        *)               _llmsh_message "llmsh: conversion failed" ;;
+    esac
+}
+
+# ============================================================================
+# Next Command Prediction Widget
+# ============================================================================
+
+_llmsh_predict_next_widget() {
+    # Pressed again: cycle through alternative commands
+    _llmsh_cycle_candidates "predict" && return
+
+    local current="$READLINE_LINE"
+
+    # Empty line: predict the next command; otherwise complete the line
+    local method
+    if [[ -z "$current" ]]; then
+        method="predict"
+    else
+        method="complete"
+    fi
+
+    # Call appropriate method
+    eval "$(_llmsh_call_binary "$method" "$current")"
+    local command="$llmsh_command"
+
+    # Ask before inserting a risky command, if configured to
+    if [[ -n "$command" ]] && ! _llmsh_check_risk; then
+        command=""
+    fi
+    _llmsh_set_candidates "predict" "$method" "$current"
+    _llmsh_track_suggestion "$command"
+
+    if [[ -n "$command" ]]; then
+        READLINE_LINE="$command"
+        READLINE_POINT=${#READLINE_LINE}
+    fi
+
+    # Over budget, answers only come from the cache or offline predictor;
+    # refused commands are not inserted at all
+    case "$llmsh_error_code" in
+        budget_exceeded) _llmsh_message "llmsh: budget exceeded, LLM calls are paused" ;;
+        unsafe_command)  _llmsh_message "llmsh: refused unsafe command" ;;
+    esac
+}
+
+# ============================================================================
+# Command Explanation Widget
+# ============================================================================
+
+_llmsh_explain_widget() {
+    local command="$READLINE_LINE"
+
+    # Don't proceed if the line is empty
+    if [[ -z "$command" ]]; then
+        return
+    fi
+
+    # Call explain; llmsh formats the explanation for display
+    eval "$(_llmsh_call_binary "explain" "$command")"
+
+    if [[ -n "$llmsh_explanation" ]]; then
+        _llmsh_message "$llmsh_explanation"
+        return
+    fi
+
+    case "$llmsh_error_code" in
+        timeout)         _llmsh_message "llmsh: explanation timed out" ;;
+        canceled)        ;;
+        budget_exceeded) _llmsh_message "llmsh: budget exceeded, LLM calls are paused" ;;
+        *)               _llmsh_message "llmsh: explanation failed" ;;
+    esac
+}
+
+# ============================================================================
+# Hooks and Keybindings
+# ============================================================================
+
+if [[ $- == *i* ]] && _llmsh_check_dependencies; then
+    # Report accepted, edited and discarded suggestions. The hook runs after
+    # the existing ones, which see the exit status of the command line first;
+    # bash 5.1 and later also accept PROMPT_COMMAND as an array.
+    if [[ " ${PROMPT_COMMAND[*]} " != *[[:space:]\;]_llmsh_prompt_command[[:space:]\;]* ]]; then
+        if [[ "$(declare -p PROMPT_COMMAND 2>/dev/null)" == "declare -a"* ]]; then
+            PROMPT_COMMAND+=(_llmsh_prompt_command)
+        else
+            PROMPT_COMMAND="${PROMPT_COMMAND:+$PROMPT_COMMAND$'\n'}_llmsh_prompt_command"
+        fi
+    fi
+
+    # Keybindings, from zsh.keybindings in the config
+{{- with index .Keybindings "nl2cmd"}}
+
+    # Natural language to command
+    bind -x '{{key .}}: _llmsh_nl2cmd_widget'
+{{- end}}
+{{- with index .Keybindings "predict"}}
+
+    # Next command prediction, or completion of the current line
+    bind -x '{{key .}}: _llmsh_predict_next_widget'
+{{- end}}
+{{- with index .Keybindings "explain"}}
+
+    # Explain the command on the current line
+    bind -x '{{key .}}: _llmsh_explain_widget'
+{{- end}}
+fi
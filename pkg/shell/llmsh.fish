@@ -0,0 +1,335 @@
+# llmsh fish integration
+# Provides natural language to command conversion, manual command prediction
+# and command explanation. Load it from ~/.config/fish/config.fish with:
+#
+#   llmsh init fish | source
+
+# ============================================================================
+# Configuration and Initialization
+# ============================================================================
+
+# Binary path, defaulting to the binary that printed this script
+set -q LLMSH_BINARY; or set -g LLMSH_BINARY {{quote .Binary}}
+
+# Number of alternatives fetched when a widget is pressed again
+set -q LLMSH_CANDIDATES; or set -g LLMSH_CANDIDATES 5
+
+# Candidate cycling state shared by the widgets
+set -g _llmsh_candidates
+set -g _llmsh_candidate_risks
+set -g _llmsh_candidate_index 0
+set -g _llmsh_candidate_widget ""
+set -g _llmsh_candidate_method ""
+set -g _llmsh_candidate_input ""
+
+# Suggestion awaiting feedback: its ID and the command shown last
+set -g _llmsh_suggestion_id ""
+set -g _llmsh_suggestion ""
+
+# Identifies this shell, whose history the offline predictor learns on its
+# own; fish does not number its history, so commands are matched by text
+set -g _llmsh_session "$fish_pid-"(random)
+
+# Check that the binary is available
+function _llmsh_check_dependencies
+    if not test -x "$LLMSH_BINARY"
+        echo "llmsh: binary not found at $LLMSH_BINARY" >&2
+        echo "llmsh: Please run 'make install' to install the binary" >&2
+        return 1
+    end
+end
+
+# ============================================================================
+# Helper Functions
+# ============================================================================
+
+# Show a message above the prompt and redraw it
+function _llmsh_message -a message
+    printf '\n%s\n' $message >&2
+    commandline -f repaint
+end
+
+# Call llmsh with the input of a method (the prefix, description or command)
+# and additional flags. The request is passed through the environment, and
+# llmsh adds the working directory, git branch and OS; a running daemon
+# serves it. The response sets the global llmsh_* variables.
+function _llmsh_call_binary -a method input
+    set -lx LLMSH_PREFIX ""
+    set -lx LLMSH_DESCRIPTION ""
+    set -lx LLMSH_COMMAND ""
+    switch $method
+        case complete
+            set LLMSH_PREFIX $input
+        case nl2cmd
+            set LLMSH_DESCRIPTION $input
+        case explain
+            set LLMSH_COMMAND $input
+    end
+
+    # Recent history, oldest first, separated by NUL bytes since commands
+    # may span several lines
+    set -l hist (history --max {{.HistoryLength}} --null | string split0)
+    if set -q hist[1]
+        set hist $hist[-1..1]
+    end
+    set -lx LLMSH_HISTORY_FILE -
+    set -lx LLMSH_SESSION $_llmsh_session
+
+    printf '%s\0' $hist |
+        $LLMSH_BINARY $method --input env --output fish $argv[3..-1] 2>/dev/null | source
+end
+
+# Show the safety warning of the last response, and ask before its command
+# is inserted when confirmation is required. Returns 1 when the user
+# declines.
+function _llmsh_check_risk
+    test -n "$llmsh_action"; or return 0
+
+    set -l warning "llmsh: $llmsh_risk risk: "(string join ', ' -- $llmsh_risk_reasons)
+    if test "$llmsh_action" != confirm
+        _llmsh_message "$warning"
+        return 0
+    end
+
+    echo >&2
+    read -l --nchars 1 --prompt-str "$warning. Insert anyway? [y/N] " key
+    commandline -f repaint
+    string match -qi y -- "$key"
+end
+
+# Remember the candidates of the last response for cycling; the first is
+# shown first
+function _llmsh_set_candidates -a widget method input
+    set -g _llmsh_candidates $llmsh_candidates
+    set -g _llmsh_candidate_risks $llmsh_candidate_risks
+    set -g _llmsh_candidate_index 1
+    set -g _llmsh_candidate_widget $widget
+    set -g _llmsh_candidate_method $method
+    set -g _llmsh_candidate_input $input
+end
+
+# Replace the command line with a command, leaving the cursor at its end
+function _llmsh_replace_buffer -a command
+    commandline -r -- $command
+    commandline -C (string length -- $command)
+    commandline -f repaint
+end
+
+# Cycle to the next candidate when a widget is pressed again on the command
+# it inserted. The first press only fetches a single command, so the second
+# one fetches alternatives for the same request. Returns 1 when the widget
+# should run a fresh request instead.
+function _llmsh_cycle_candidates -a widget
+    set -q _llmsh_candidates[1]; or return 1
+    test "$_llmsh_candidate_widget" = "$widget"; or return 1
+    set -l current (commandline | string collect)
+    test "$current" = "$_llmsh_candidates[$_llmsh_candidate_index]"; or return 1
+
+    if test (count $_llmsh_candidates) -eq 1
+        set -l method $_llmsh_candidate_method
+        set -l input $_llmsh_candidate_input
+        _llmsh_call_binary $method "$input" --candidates $LLMSH_CANDIDATES
+        _llmsh_set_candidates $widget $method "$input"
+
+        # Continue after the command already shown, keeping it in the cycle
+        set -l index (contains --index -- $current $_llmsh_candidates)
+        if test -z "$index"
+            set -g _llmsh_candidates $current $_llmsh_candidates
+            set -g _llmsh_candidate_risks - $_llmsh_candidate_risks
+            set index 1
+        end
+        set -g _llmsh_candidate_index $index
+    end
+
+    if test (count $_llmsh_candidates) -le 1
+        _llmsh_message "llmsh: no alternatives"
+        return 0
+    end
+
+    set -g _llmsh_candidate_index (math $_llmsh_candidate_index % (count $_llmsh_candidates) + 1)
+    set -l command $_llmsh_candidates[$_llmsh_candidate_index]
+    _llmsh_replace_buffer $command
+    # Feedback refers to the first suggestion and reports the candidate chosen
+    test -n "$_llmsh_suggestion_id"; and set -g _llmsh_suggestion $command
+
+    # Messages stay in the scrollback in fish, so only flagged ones are shown
+    set -l risk $_llmsh_candidate_risks[$_llmsh_candidate_index]
+    if test -n "$risk" -a "$risk" != -
+        _llmsh_message "llmsh: candidate $_llmsh_candidate_index/"(count $_llmsh_candidates)" ($risk risk)"
+    end
+    return 0
+end
+
+# Report what became of the pending suggestion, given the command line that
+# was executed (empty when it was replaced). Runs in the background so the
+# prompt never waits for it.
+function _llmsh_send_feedback -a executed
+    test -n "$_llmsh_suggestion_id"; or return 0
+
+    set -lx LLMSH_SUGGESTION_ID $_llmsh_suggestion_id
+    set -lx LLMSH_SUGGESTION $_llmsh_suggestion
+    set -lx LLMSH_EXECUTED $executed
+    $LLMSH_BINARY feedback --input env >/dev/null 2>&1 &
+    disown 2>/dev/null
+
+    set -g _llmsh_suggestion_id ""
+    set -g _llmsh_suggestion ""
+end
+
+# Start tracking the suggestion of the last response; a suggestion still
+# pending is replaced, so it counts as discarded
+function _llmsh_track_suggestion -a command
+    _llmsh_send_feedback ""
+
+    test -n "$command"; or return 0
+    set -g _llmsh_suggestion_id $llmsh_suggestion_id
+    set -g _llmsh_suggestion $command
+end
+
+# preexec hook: compare the executed command line with the suggestion
+function _llmsh_preexec --on-event fish_preexec
+    _llmsh_send_feedback $argv[1]
+end
+
+# ============================================================================
+# Natural Language to Command Widget
+# ============================================================================
+
+function _llmsh_nl2cmd_widget
+    # Pressed again: cycle through alternative commands
+    _llmsh_cycle_candidates nl2cmd; and return
+
+    # The command line is the natural language description
+    set -l description (commandline | string collect)
+
+    # Don't proceed if the command line is empty
+    test -n "$description"; or return
+
+    # Call nl2cmd
+    _llmsh_call_binary nl2cmd "$description"
+    set -l command $llmsh_command
+
+    # Ask before inserting a risky command, if configured to
+    set -l declined 0
+    if test -n "$command"; and not _llmsh_check_risk
+        set command ""
+        set declined 1
+    end
+    _llmsh_set_candidates nl2cmd nl2cmd "$description"
+    _llmsh_track_suggestion "$command"
+
+    if test -n "$command"
+        # Replace the description with the generated command
+        _llmsh_replace_buffer $command
+        return
+    end
+
+    # Keep the description and report the error, if it was not declined
+    test $declined -eq 1; and return
+    switch "$llmsh_error_code"
+        case timeout
+            _llmsh_message "llmsh: timed out"
+        case canceled
+        case budget_exceeded
+            _llmsh_message "llmsh: budget exceeded, LLM calls are paused"
+        case unsafe_command
+            _llmsh_message "llmsh: refused unsafe command"
+        case '*'
+            _llmsh_message "llmsh: conversion failed"
+    end
+end
+
+# ============================================================================
+# Next Command Prediction Widget
+# ============================================================================
+
+function _llmsh_predict_next_widget
+    # Pressed again: cycle through alternative commands
+    _llmsh_cycle_candidates predict; and return
+
+    set -l current (commandline | string collect)
+
+    # Empty command line: predict the next command; otherwise complete it
+    set -l method predict
+    if test -n "$current"
+        set method complete
+    end
+
+    # Call appropriate method
+    _llmsh_call_binary $method "$current"
+    set -l command $llmsh_command
+
+    # Ask before inserting a risky command, if configured to
+    if test -n "$command"; and not _llmsh_check_risk
+        set command ""
+    end
+    _llmsh_set_candidates predict $method "$current"
+    _llmsh_track_suggestion "$command"
+
+    if test -n "$command"
+        _llmsh_replace_buffer $command
+    end
+
+    # Over budget, answers only come from the cache or offline predictor;
+    # refused commands are not inserted at all
+    switch "$llmsh_error_code"
+        case budget_exceeded
+            _llmsh_message "llmsh: budget exceeded, LLM calls are paused"
+        case unsafe_command
+            _llmsh_message "llmsh: refused unsafe command"
+    end
+end
+
+# ============================================================================
+# Command Explanation Widget
+# ============================================================================
+
+function _llmsh_explain_widget
+    set -l command (commandline | string collect)
+
+    # Don't proceed if the command line is empty
+    test -n "$command"; or return
+
+    # Call explain; llmsh formats the explanation for display
+    _llmsh_call_binary explain "$command"
+
+    if test -n "$llmsh_explanation"
+        _llmsh_message "$llmsh_explanation"
+        return
+    end
+
+    switch "$llmsh_error_code"
+        case timeout
+            _llmsh_message "llmsh: explanation timed out"
+        case canceled
+        case budget_exceeded
+            _llmsh_message "llmsh: budget exceeded, LLM calls are paused"
+        case '*'
+            _llmsh_message "llmsh: explanation failed"
+    end
+end
+
+# ============================================================================
+# Keybindings
+# ============================================================================
+
+if status is-interactive; and _llmsh_check_dependencies
+    # Keybindings, from zsh.keybindings in the config, in the default mode
+    # and in vi insert mode
+    for mode in default insert
+{{- with index .Keybindings "nl2cmd"}}
+        # Natural language to command
+        bind -M $mode {{key .}} _llmsh_nl2cmd_widget
+{{- end}}
+{{- with index .Keybindings "predict"}}
+
+        # Next command prediction, or completion of the command line
+        bind -M $mode {{key .}} _llmsh_predict_next_widget
+{{- end}}
+{{- with index .Keybindings "explain"}}
+
+        # Explain the command on the command line
+        bind -M $mode {{key .}} _llmsh_explain_widget
+{{- end}}
+    end
+end
@@ -0,0 +1,134 @@
+// Package shell holds the integrations that connect interactive shells to
+// the llmsh binary
+package shell
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ErrUnsupportedShell is returned for shells without an integration
+var ErrUnsupportedShell = errors.New("unsupported shell")
+
+// Settings are the values the integration scripts are rendered with
+type Settings struct {
+	// Binary is the default path of the llmsh binary; LLMSH_BINARY
+	// overrides it
+	Binary string
+	// SocketPath is the default socket of the daemon, which zsh talks to
+	// directly; LLMSH_SOCKET overrides it
+	SocketPath string
+	// HistoryLength is how many of the last commands are sent as context
+	HistoryLength int
+	// Keybindings maps the widgets (nl2cmd, predict, explain) to the key
+	// sequences bound to them, in zsh bindkey notation which the bash and
+	// fish scripts convert; widgets missing are left unbound
+	Keybindings map[string]string
+	// Timeouts bounds how long zsh, which talks to the daemon itself, waits
+	// for the answer to each method; methods missing wait without limit.
+	// Bash and fish leave it to the llmsh binary.
+	Timeouts map[string]time.Duration
+}
+
+//go:embed llmsh.zsh
+var zshScript string
+
+//go:embed llmsh.bash
+var bashScript string
+
+//go:embed llmsh.fish
+var fishScript string
+
+// scripts maps each supported shell to its integration script, a template
+// rendered with Settings
+var scripts = map[string]*template.Template{
+	"zsh":  parse("zsh", zshScript, posixQuote, zshKey),
+	"bash": parse("bash", bashScript, posixQuote, bashKey),
+	"fish": parse("fish", fishScript, QuoteFish, fishKey),
+}
+
+// parse parses an integration script, whose quote function quotes a string
+// as a single word of its shell, and whose key function writes a key
+// sequence in bindkey notation as its shell binds it
+func parse(name, script string, quote func(string) string, key func(string) (string, error)) *template.Template {
+	funcs := template.FuncMap{
+		"quote":   quote,
+		"key":     key,
+		"seconds": seconds,
+	}
+	return template.Must(template.New(name).Funcs(funcs).Parse(script))
+}
+
+// zshKey quotes a key sequence for bindkey, which reads the notation itself
+func zshKey(seq string) (string, error) {
+	return posixQuote(seq), nil
+}
+
+// seconds formats a duration as a number of seconds
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
+// posixQuote quotes a string for POSIX shells such as zsh and bash
+func posixQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// QuoteSh quotes a string as a single word for zsh and bash, using $'...'
+// quoting so that it stays on one line
+func QuoteSh(s string) string {
+	var b strings.Builder
+	b.WriteString("$'")
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '\'':
+			b.WriteString(`\` + string(r))
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteString("'")
+	return b.String()
+}
+
+// QuoteFish quotes a string as a single word for fish, where backslashes are
+// special within single quotes
+func QuoteFish(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+// Script returns the integration script of a shell, to be evaluated by it
+func Script(name string, settings Settings) (string, error) {
+	tmpl, ok := scripts[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedShell, name)
+	}
+
+	var script strings.Builder
+	if err := tmpl.Execute(&script, settings); err != nil {
+		return "", fmt.Errorf("render %s script: %w", name, err)
+	}
+	return script.String(), nil
+}
+
+// Shells returns the supported shells in sorted order
+func Shells() []string {
+	shells := make([]string, 0, len(scripts))
+	for name := range scripts {
+		shells = append(shells, name)
+	}
+	sort.Strings(shells)
+	return shells
+}
@@ -0,0 +1,86 @@
+package tracker
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"llmsh/pkg/config"
+)
+
+// ErrBudgetExceeded is returned when tracked usage reached a budget limit
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// usage sums the tokens and cost of records
+type usage struct {
+	tokens int
+	cost   float64
+}
+
+func (u *usage) add(s *Summary) {
+	u.tokens += s.InputTokens + s.OutputTokens + s.CacheCreationTokens + s.CacheReadTokens
+	if cost, ok := s.Cost(); ok {
+		u.cost += cost
+	}
+}
+
+// CheckBudget returns an error wrapping ErrBudgetExceeded when the usage
+// recorded today or this month, overall or for the provider, reached a limit.
+// Costs are computed with the pricing set by SetPricing. Unreadable records
+// never block calls.
+func CheckBudget(budget config.BudgetConfig, provider string) error {
+	if !budget.Enabled() {
+		return nil
+	}
+
+	now := time.Now()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+	summaries, err := Summarize(monthStart)
+	if err != nil {
+		return nil
+	}
+
+	today := now.Format("2006-01-02")
+	var daily, monthly, providerDaily, providerMonthly usage
+	for i := range summaries {
+		s := &summaries[i]
+		isToday := s.Day == today
+
+		monthly.add(s)
+		if isToday {
+			daily.add(s)
+		}
+		if s.Provider == provider {
+			providerMonthly.add(s)
+			if isToday {
+				providerDaily.add(s)
+			}
+		}
+	}
+	if err := checkLimit("daily", budget.Daily, daily); err != nil {
+		return err
+	}
+	if err := checkLimit("monthly", budget.Monthly, monthly); err != nil {
+		return err
+	}
+
+	periods, ok := budget.Providers[provider]
+	if !ok {
+		return nil
+	}
+	if err := checkLimit("daily "+provider, periods.Daily, providerDaily); err != nil {
+		return err
+	}
+	return checkLimit("monthly "+provider, periods.Monthly, providerMonthly)
+}
+
+// checkLimit compares usage with a limit
+func checkLimit(name string, limit config.BudgetLimit, u usage) error {
+	if limit.Tokens > 0 && u.tokens >= limit.Tokens {
+		return fmt.Errorf("%w: %s token limit of %d reached", ErrBudgetExceeded, name, limit.Tokens)
+	}
+	if limit.Cost > 0 && u.cost >= limit.Cost {
+		return fmt.Errorf("%w: %s cost limit of $%.2f reached", ErrBudgetExceeded, name, limit.Cost)
+	}
+	return nil
+}
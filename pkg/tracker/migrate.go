@@ -0,0 +1,74 @@
+package tracker
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// migratedKey marks in the meta table that legacy JSON records were imported
+const migratedKey = "migrated_json"
+
+// legacyStorage is the layout of the tokens.json file used before SQLite
+type legacyStorage struct {
+	Version string   `json:"version"`
+	Records []Record `json:"records"`
+}
+
+// migrateJSON imports the records of a legacy tokens.json file once, then
+// renames the file aside. The import runs in a write transaction that also
+// marks it done, so concurrent shells cannot import the records twice. A
+// damaged file is salvaged record by record and kept aside as corrupt.
+func migrateJSON(conn *sql.DB, jsonPath string) error {
+	if _, err := os.Stat(jsonPath); err != nil {
+		return nil
+	}
+
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var done string
+	err = tx.QueryRow("SELECT value FROM meta WHERE key = ?", migratedKey).Scan(&done)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	data, err := os.ReadFile(jsonPath)
+	if err != nil {
+		return nil
+	}
+
+	var storage legacyStorage
+	renameTo := jsonPath + ".migrated"
+	if err := json.Unmarshal(data, &storage); err != nil {
+		storage.Records = salvageJSON(data)
+		renameTo = jsonPath + corruptSuffix + time.Now().Format("20060102-150405")
+		fmt.Fprintf(os.Stderr, "llmsh: %s is corrupt, salvaged %d records and kept it aside as %s\n", jsonPath, len(storage.Records), renameTo)
+	}
+
+	for i := range storage.Records {
+		if err := insertRecord(tx, &storage.Records[i]); err != nil {
+			return fmt.Errorf("migrate %s: %w", jsonPath, err)
+		}
+	}
+
+	if _, err := tx.Exec("INSERT INTO meta (key, value) VALUES (?, ?)", migratedKey, jsonPath); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// Keep the old file around, out of the way
+	os.Rename(jsonPath, renameTo)
+	return nil
+}
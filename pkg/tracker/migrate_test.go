@@ -0,0 +1,91 @@
+package tracker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useTestDB points the tracker at a store in a temporary directory and
+// returns the directory
+func useTestDB(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	SetDBPath(filepath.Join(dir, "tokens.db"))
+	t.Cleanup(func() { Close() })
+	return dir
+}
+
+// countRecords returns the number of records in the store
+func countRecords(t *testing.T) int {
+	t.Helper()
+	summaries, err := Summarize(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, s := range summaries {
+		n += s.Count
+	}
+	return n
+}
+
+func TestMigrateJSON(t *testing.T) {
+	const records = `{"version": "1", "records": [
+		{"timestamp": "2025-01-02T10:00:00Z", "method": "predict", "provider": "openai", "model": "gpt-4o-mini", "input_tokens": 10, "output_tokens": 2},
+		{"timestamp": "2025-01-02T11:00:00Z", "method": "nl2cmd", "provider": "openai", "model": "gpt-4o-mini", "input_tokens": 20, "output_tokens": 4},
+		{"timestamp": "2025-01-03T09:00:00Z", "method": "predict", "provider": "openai", "model": "gpt-4o-mini", "input_tokens": 30, "output_tokens": 6}`
+
+	tests := []struct {
+		name        string
+		data        string
+		wantRecords int
+		// wantAside is the pattern of the name the file is kept aside as
+		wantAside string
+	}{
+		{
+			name:        "intact file",
+			data:        records + "]}",
+			wantRecords: 3,
+			wantAside:   "tokens.json.migrated",
+		},
+		{
+			name:        "truncated file",
+			data:        records[:len(records)-20],
+			wantRecords: 2,
+			wantAside:   "tokens.json" + corruptSuffix + "*",
+		},
+		{
+			name:      "not a usage file",
+			data:      "{",
+			wantAside: "tokens.json" + corruptSuffix + "*",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := useTestDB(t)
+			jsonPath := filepath.Join(dir, "tokens.json")
+			if err := os.WriteFile(jsonPath, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if n := countRecords(t); n != tt.wantRecords {
+				t.Errorf("migrated %d records, want %d", n, tt.wantRecords)
+			}
+			if matches, _ := filepath.Glob(filepath.Join(dir, tt.wantAside)); len(matches) != 1 {
+				t.Errorf("tokens.json was not kept aside as %s", tt.wantAside)
+			}
+
+			// A file that shows up again is not imported twice
+			if err := os.WriteFile(jsonPath, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			Close()
+			if n := countRecords(t); n != tt.wantRecords {
+				t.Errorf("%d records after reopening, want %d", n, tt.wantRecords)
+			}
+		})
+	}
+}
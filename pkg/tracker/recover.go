@@ -0,0 +1,264 @@
+package tracker
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	// corruptSuffix marks a corrupt store kept aside for repair
+	corruptSuffix = ".corrupt-"
+	// repairedSuffix marks a backup whose records were salvaged
+	repairedSuffix = ".repaired"
+	// unrecoverableSuffix marks a backup with nothing left to salvage
+	unrecoverableSuffix = ".unrecoverable"
+	// salvageWindow is the number of row IDs read at once from a corrupt
+	// store; a window that fails to read is skipped
+	salvageWindow = 500
+)
+
+// isCorrupt reports whether err means the database file is damaged
+func isCorrupt(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.Code == sqlite3.ErrCorrupt || sqliteErr.Code == sqlite3.ErrNotADB
+}
+
+// withDB runs fn on the database. When the store turns out to be corrupt, it
+// is kept aside for 'stats --repair' and fn runs again on a fresh store, so
+// tracking never overwrites or stops on a damaged file.
+func withDB(fn func(*sql.DB) error) error {
+	conn, err := openDB()
+	if err == nil {
+		err = fn(conn)
+	}
+	if !isCorrupt(err) {
+		return err
+	}
+
+	if qErr := quarantine(); qErr != nil {
+		return errors.Join(err, qErr)
+	}
+
+	conn, err = openDB()
+	if err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// quarantine closes the store and renames it aside, unless another process
+// already replaced it with a healthy one
+func quarantine() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if db != nil {
+		db.Close()
+		db = nil
+	}
+
+	if healthy(dbPath) {
+		return nil
+	}
+
+	backup := dbPath + corruptSuffix + time.Now().Format("20060102-150405")
+	if err := os.Rename(dbPath, backup); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("keep corrupt usage store aside: %w", err)
+	}
+	for _, suffix := range []string{"-wal", "-shm"} {
+		os.Rename(dbPath+suffix, backup+suffix)
+	}
+
+	fmt.Fprintf(os.Stderr, "llmsh: usage store %s is corrupt, kept aside as %s; run 'llmsh stats --repair' to recover it\n", dbPath, backup)
+	return nil
+}
+
+// healthy reports whether the file at path is a readable database
+func healthy(path string) bool {
+	conn, err := sql.Open("sqlite3", path+"?mode=ro")
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	var result string
+	if err := conn.QueryRow("PRAGMA quick_check").Scan(&result); err != nil {
+		// A missing file was already moved aside
+		_, statErr := os.Stat(path)
+		return os.IsNotExist(statErr)
+	}
+	return result == "ok"
+}
+
+// Backups returns the corrupt stores kept aside and not yet repaired
+func Backups() ([]string, error) {
+	mu.Lock()
+	path := dbPath
+	mu.Unlock()
+
+	matches, err := filepath.Glob(path + corruptSuffix + "*")
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []string
+	for _, match := range matches {
+		// Journal files travel with their database
+		if strings.HasSuffix(match, "-wal") || strings.HasSuffix(match, "-shm") ||
+			strings.HasSuffix(match, repairedSuffix) || strings.HasSuffix(match, unrecoverableSuffix) {
+			continue
+		}
+		backups = append(backups, match)
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// RepairResult reports the records salvaged from one backup
+type RepairResult struct {
+	Backup    string
+	Recovered int
+	// Err is set when the backup could not be read at all
+	Err error
+}
+
+// Repair salvages the readable records of every corrupt store kept aside
+// into the current store, then marks each backup as repaired, or as
+// unrecoverable when it cannot be read at all
+func Repair() ([]RepairResult, error) {
+	backups, err := Backups()
+	if err != nil {
+		return nil, err
+	}
+
+	var results []RepairResult
+	for _, backup := range backups {
+		records, err := salvageDB(backup)
+		result := RepairResult{Backup: backup, Recovered: len(records), Err: err}
+		if err != nil {
+			os.Rename(backup, backup+unrecoverableSuffix)
+		} else {
+			err = withDB(func(conn *sql.DB) error {
+				return insertRecords(conn, records)
+			})
+			if err != nil {
+				return results, fmt.Errorf("restore records from %s: %w", backup, err)
+			}
+			os.Rename(backup, backup+repairedSuffix)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// insertRecords stores records in a single transaction
+func insertRecords(conn *sql.DB, records []Record) error {
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := range records {
+		if err := insertRecord(tx, &records[i]); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// salvageDB reads whatever records are still readable from a corrupt store,
+// skipping ranges of rows that fail to read
+func salvageDB(path string) ([]Record, error) {
+	conn, err := sql.Open("sqlite3", path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var maxID int64
+	if err := conn.QueryRow("SELECT COALESCE(MAX(id), 0) FROM usage").Scan(&maxID); err != nil {
+		// The index of row IDs is gone; try a plain scan
+		records, scanErr := readRecords(conn, "SELECT "+recordColumns+" FROM usage")
+		if len(records) == 0 && scanErr != nil {
+			return nil, fmt.Errorf("unreadable: %w", err)
+		}
+		return records, nil
+	}
+
+	var records []Record
+	for from := int64(0); from < maxID; from += salvageWindow {
+		window, _ := readRecords(conn,
+			"SELECT "+recordColumns+" FROM usage WHERE id > ? AND id <= ?", from, from+salvageWindow)
+		records = append(records, window...)
+	}
+	return records, nil
+}
+
+// recordColumns are the columns read back into a Record
+const recordColumns = `timestamp, method, provider, model, input_tokens,
+	output_tokens, cache_creation_tokens, cache_read_tokens`
+
+// readRecords returns the records of a query, up to the first unreadable row
+func readRecords(conn *sql.DB, query string, args ...any) ([]Record, error) {
+	rows, err := conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []Record
+	for rows.Next() {
+		var r Record
+		var timestamp int64
+		if err := rows.Scan(&timestamp, &r.Method, &r.Provider, &r.Model, &r.InputTokens,
+			&r.OutputTokens, &r.CacheCreationTokens, &r.CacheReadTokens); err != nil {
+			return records, err
+		}
+		r.Timestamp = time.Unix(timestamp, 0)
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// salvageJSON decodes the records of a damaged tokens.json one by one, up
+// to the first one that cannot be read, e.g. where the file was truncated
+func salvageJSON(data []byte) []Record {
+	start := bytes.Index(data, []byte(`"records"`))
+	if start < 0 {
+		return nil
+	}
+	open := bytes.IndexByte(data[start:], '[')
+	if open < 0 {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data[start+open:]))
+
+	// Enter the array
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return nil
+	}
+
+	var records []Record
+	for decoder.More() {
+		var r Record
+		if err := decoder.Decode(&r); err != nil {
+			break
+		}
+		records = append(records, r)
+	}
+	return records
+}
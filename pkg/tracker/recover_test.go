@@ -0,0 +1,146 @@
+package tracker
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeStore creates a store at path holding n records
+func writeStore(t *testing.T, path string, n int) {
+	t.Helper()
+	SetDBPath(path)
+	conn, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := make([]Record, n)
+	for i := range records {
+		records[i] = Record{Method: "predict", Provider: "openai", Model: "gpt-4o-mini", InputTokens: i}
+	}
+	if err := insertRecords(conn, records); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		t.Fatal(err)
+	}
+	Close()
+}
+
+// damagePage overwrites one page of a database file with garbage
+func damagePage(t *testing.T, path string, page int64) {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", path+"?mode=ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pageSize int64
+	err = conn.QueryRow("PRAGMA page_size").Scan(&pageSize)
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	garbage := make([]byte, pageSize)
+	for i := range garbage {
+		garbage[i] = 0xa5
+	}
+	if _, err := f.WriteAt(garbage, (page-1)*pageSize); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSalvageDB(t *testing.T) {
+	const total = 2000
+
+	tests := []struct {
+		name string
+		// damage breaks the store at path
+		damage  func(t *testing.T, path string)
+		wantMin int
+		wantMax int
+		wantErr bool
+	}{
+		{
+			name:    "readable store",
+			damage:  func(*testing.T, string) {},
+			wantMin: total,
+			wantMax: total,
+		},
+		{
+			name:    "damaged page",
+			damage:  func(t *testing.T, path string) { damagePage(t, path, 10) },
+			wantMin: 1,
+			wantMax: total - 1,
+		},
+		{
+			name: "not a database",
+			damage: func(t *testing.T, path string) {
+				if err := os.WriteFile(path, []byte("not a database at all"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tokens.db")
+			writeStore(t, path, total)
+			tt.damage(t, path)
+
+			records, err := salvageDB(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("salvageDB() error = %v, want error %v", err, tt.wantErr)
+			}
+			if n := len(records); n < tt.wantMin || n > tt.wantMax {
+				t.Errorf("salvaged %d records, want %d to %d", n, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestCorruptStore(t *testing.T) {
+	dir := useTestDB(t)
+	path := filepath.Join(dir, "tokens.db")
+	if err := os.WriteFile(path, []byte("not a database at all"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tracking goes on in a fresh store
+	if err := RecordUsage(&Record{Method: "predict", Provider: "openai", Model: "gpt-4o-mini"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRecords(t); n != 1 {
+		t.Errorf("%d records in the fresh store, want 1", n)
+	}
+
+	backups, err := Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("Backups() = %v, want the corrupt store", backups)
+	}
+
+	results, err := Repair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Err == nil {
+		t.Errorf("Repair() = %+v, want the backup reported unrecoverable", results)
+	}
+	if _, err := os.Stat(backups[0] + unrecoverableSuffix); err != nil {
+		t.Errorf("backup not marked unrecoverable: %v", err)
+	}
+	if backups, _ := Backups(); len(backups) != 0 {
+		t.Errorf("Backups() after repair = %v, want none", backups)
+	}
+}
@@ -1,17 +1,20 @@
 package tracker
 
 import (
-	"encoding/json"
-	"fmt"
+	"database/sql"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
+
+	"llmsh/pkg/config"
+
+	_ "github.com/mattn/go-sqlite3"
 )
 
 // Record represents a single token usage record
 type Record struct {
-	ID                  string    `json:"id"`
 	Timestamp           time.Time `json:"timestamp"`
 	Method              string    `json:"method"`
 	Provider            string    `json:"provider"`
@@ -22,105 +25,273 @@ type Record struct {
 	CacheReadTokens     int       `json:"cache_read_tokens"`
 }
 
-// Storage represents the JSON storage structure
-type Storage struct {
-	Version string   `json:"version"`
-	Records []Record `json:"records"`
+// Summary sums the records of one day, method, provider and model
+type Summary struct {
+	Day                 string
+	Method              string
+	Provider            string
+	Model               string
+	Count               int
+	InputTokens         int
+	OutputTokens        int
+	CacheCreationTokens int
+	CacheReadTokens     int
 }
 
 var (
 	mu     sync.Mutex
 	dbPath string
+	db     *sql.DB
+
+	// priceLookup finds the price of a provider's model
+	priceLookup func(provider, model string) (config.ModelPrice, bool)
 )
 
 func init() {
 	home, _ := os.UserHomeDir()
-	dbPath = filepath.Join(home, ".llmsh", "tokens.json")
+	dbPath = filepath.Join(home, ".llmsh", "tokens.db")
 }
 
-// SetDBPath sets a custom database path (useful for testing)
+// SetDBPath sets a custom database path. A path to a legacy tokens.json
+// file selects the database next to it, which the file is migrated into.
 func SetDBPath(path string) {
 	mu.Lock()
 	defer mu.Unlock()
+
+	if strings.HasSuffix(path, ".json") {
+		path = strings.TrimSuffix(path, ".json") + ".db"
+	}
+	if path == dbPath {
+		return
+	}
+	if db != nil {
+		db.Close()
+		db = nil
+	}
 	dbPath = path
 }
 
-// RecordUsage records a new token usage entry
-func RecordUsage(r *Record) error {
+// Close closes the database connection, if open
+func Close() error {
 	mu.Lock()
 	defer mu.Unlock()
 
-	r.ID = generateID()
-	r.Timestamp = time.Now()
+	if db == nil {
+		return nil
+	}
+	err := db.Close()
+	db = nil
+	return err
+}
+
+// openDB returns the database, opening it and migrating legacy JSON records
+// on first use
+func openDB() (*sql.DB, error) {
+	mu.Lock()
+	defer mu.Unlock()
 
-	// Read existing data
-	storage := &Storage{Version: "1.0"}
-	if data, err := os.ReadFile(dbPath); err == nil {
-		json.Unmarshal(data, storage)
+	if db != nil {
+		return db, nil
 	}
 
-	// Add new record
-	storage.Records = append(storage.Records, *r)
+	// Ensure the directory exists
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+		return nil, err
+	}
 
-	// Write back to file
-	data, err := json.MarshalIndent(storage, "", "  ")
+	// WAL lets concurrent shells read while one writes; writers wait for
+	// each other instead of failing
+	conn, err := sql.Open("sqlite3", dbPath+"?_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Ensure directory exists
-	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
-		return err
+	schema := `
+	CREATE TABLE IF NOT EXISTS usage (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp INTEGER NOT NULL,
+		method TEXT NOT NULL,
+		provider TEXT NOT NULL,
+		model TEXT NOT NULL,
+		input_tokens INTEGER NOT NULL,
+		output_tokens INTEGER NOT NULL,
+		cache_creation_tokens INTEGER NOT NULL,
+		cache_read_tokens INTEGER NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_usage_timestamp ON usage(timestamp);
+
+	CREATE TABLE IF NOT EXISTS meta (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);
+	`
+
+	if _, err := conn.Exec(schema); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := migrateJSON(conn, strings.TrimSuffix(dbPath, filepath.Ext(dbPath))+".json"); err != nil {
+		conn.Close()
+		return nil, err
 	}
 
-	return os.WriteFile(dbPath, data, 0644)
+	db = conn
+	return db, nil
 }
 
-// LoadRecords loads all token usage records
-func LoadRecords() (*Storage, error) {
-	mu.Lock()
-	defer mu.Unlock()
+// RecordUsage records a new token usage entry
+func RecordUsage(r *Record) error {
+	r.Timestamp = time.Now()
+	return withDB(func(conn *sql.DB) error {
+		return insertRecord(conn, r)
+	})
+}
+
+// execer is implemented by *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
 
-	data, err := os.ReadFile(dbPath)
+// insertRecord stores a record
+func insertRecord(e execer, r *Record) error {
+	_, err := e.Exec(`
+		INSERT INTO usage (timestamp, method, provider, model, input_tokens,
+			output_tokens, cache_creation_tokens, cache_read_tokens)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, r.Timestamp.Unix(), r.Method, r.Provider, r.Model, r.InputTokens,
+		r.OutputTokens, r.CacheCreationTokens, r.CacheReadTokens)
+	return err
+}
+
+// Summarize sums the records since a point in time by local day, method,
+// provider and model, oldest day first. A zero time summarizes all records.
+func Summarize(since time.Time) ([]Summary, error) {
+	var from int64
+	if !since.IsZero() {
+		from = since.Unix()
+	}
+
+	var summaries []Summary
+	err := withDB(func(conn *sql.DB) error {
+		var err error
+		summaries, err = summarize(conn, from)
+		return err
+	})
+	return summaries, err
+}
+
+// summarize runs the query behind Summarize
+func summarize(conn *sql.DB, from int64) ([]Summary, error) {
+	rows, err := conn.Query(`
+		SELECT strftime('%Y-%m-%d', timestamp, 'unixepoch', 'localtime') AS day,
+			method, provider, model, COUNT(*),
+			SUM(input_tokens), SUM(output_tokens),
+			SUM(cache_creation_tokens), SUM(cache_read_tokens)
+		FROM usage
+		WHERE timestamp >= ?
+		GROUP BY day, method, provider, model
+		ORDER BY day
+	`, from)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return &Storage{Version: "1.0", Records: []Record{}}, nil
-		}
 		return nil, err
 	}
+	defer rows.Close()
+
+	var summaries []Summary
+	for rows.Next() {
+		var s Summary
+		if err := rows.Scan(&s.Day, &s.Method, &s.Provider, &s.Model, &s.Count,
+			&s.InputTokens, &s.OutputTokens, &s.CacheCreationTokens, &s.CacheReadTokens); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries, rows.Err()
+}
 
-	var storage Storage
-	if err := json.Unmarshal(data, &storage); err != nil {
-		return nil, err
+// SetPricing sets the price lookup used to compute costs, typically
+// (*config.Config).Price
+func SetPricing(lookup func(provider, model string) (config.ModelPrice, bool)) {
+	mu.Lock()
+	defer mu.Unlock()
+	priceLookup = lookup
+}
+
+// Cost returns the cost of summed records in dollars, and false when their
+// model has no price
+func (s *Summary) Cost() (float64, bool) {
+	mu.Lock()
+	lookup := priceLookup
+	mu.Unlock()
+
+	if lookup == nil {
+		return 0, false
+	}
+	price, ok := lookup(s.Provider, s.Model)
+	if !ok {
+		return 0, false
+	}
+
+	// Unpriced cache tokens cost as much as regular input
+	cachedInput := price.CachedInput
+	if cachedInput == 0 {
+		cachedInput = price.Input
 	}
+	cacheWrite := price.CacheWrite
+	if cacheWrite == 0 {
+		cacheWrite = price.Input
+	}
+
+	cost := float64(s.InputTokens)*price.Input +
+		float64(s.OutputTokens)*price.Output +
+		float64(s.CacheReadTokens)*cachedInput +
+		float64(s.CacheCreationTokens)*cacheWrite
+	return cost / 1e6, true
+}
+
+// Costs accumulates the cost of records, counting those without a price
+type Costs struct {
+	Cost     float64
+	Unpriced int
+}
 
-	return &storage, nil
+// Add adds the cost of summed records
+func (c *Costs) Add(s *Summary) {
+	if cost, ok := s.Cost(); ok {
+		c.Cost += cost
+	} else {
+		c.Unpriced += s.Count
+	}
 }
 
 // DayStats represents aggregated statistics for a single day
 type DayStats struct {
-	Day             string
-	Count           int
-	InputTokens     int
-	OutputTokens    int
-	CacheReadTokens int
+	Day                 string
+	Count               int
+	InputTokens         int
+	OutputTokens        int
+	CacheCreationTokens int
+	CacheReadTokens     int
+	Costs
 }
 
-// AggregateByDay aggregates records by day
-func AggregateByDay(records []Record) map[string]*DayStats {
+// AggregateByDay aggregates summaries by day
+func AggregateByDay(summaries []Summary) map[string]*DayStats {
 	stats := make(map[string]*DayStats)
 
-	for _, r := range records {
-		day := r.Timestamp.Format("2006-01-02")
-
-		if stats[day] == nil {
-			stats[day] = &DayStats{Day: day}
+	for _, s := range summaries {
+		if stats[s.Day] == nil {
+			stats[s.Day] = &DayStats{Day: s.Day}
 		}
 
-		stats[day].Count++
-		stats[day].InputTokens += r.InputTokens
-		stats[day].OutputTokens += r.OutputTokens
-		stats[day].CacheReadTokens += r.CacheReadTokens
+		stats[s.Day].Count += s.Count
+		stats[s.Day].InputTokens += s.InputTokens
+		stats[s.Day].OutputTokens += s.OutputTokens
+		stats[s.Day].CacheCreationTokens += s.CacheCreationTokens
+		stats[s.Day].CacheReadTokens += s.CacheReadTokens
+		stats[s.Day].Add(&s)
 	}
 
 	return stats
@@ -132,20 +303,22 @@ type MethodStats struct {
 	Count        int
 	InputTokens  int
 	OutputTokens int
+	Costs
 }
 
-// AggregateByMethod aggregates records by method
-func AggregateByMethod(records []Record) map[string]*MethodStats {
+// AggregateByMethod aggregates summaries by method
+func AggregateByMethod(summaries []Summary) map[string]*MethodStats {
 	stats := make(map[string]*MethodStats)
 
-	for _, r := range records {
-		if stats[r.Method] == nil {
-			stats[r.Method] = &MethodStats{Method: r.Method}
+	for _, s := range summaries {
+		if stats[s.Method] == nil {
+			stats[s.Method] = &MethodStats{Method: s.Method}
 		}
 
-		stats[r.Method].Count++
-		stats[r.Method].InputTokens += r.InputTokens
-		stats[r.Method].OutputTokens += r.OutputTokens
+		stats[s.Method].Count += s.Count
+		stats[s.Method].InputTokens += s.InputTokens
+		stats[s.Method].OutputTokens += s.OutputTokens
+		stats[s.Method].Add(&s)
 	}
 
 	return stats
@@ -153,31 +326,35 @@ func AggregateByMethod(records []Record) map[string]*MethodStats {
 
 // ProviderModelStats represents aggregated statistics by provider and model
 type ProviderModelStats struct {
-	Provider     string
-	Model        string
-	Count        int
-	InputTokens  int
-	OutputTokens int
-	CacheReadTokens int
+	Provider            string
+	Model               string
+	Count               int
+	InputTokens         int
+	OutputTokens        int
+	CacheCreationTokens int
+	CacheReadTokens     int
+	Costs
 }
 
-// AggregateByProviderModel aggregates records by provider and model
-func AggregateByProviderModel(records []Record) []*ProviderModelStats {
+// AggregateByProviderModel aggregates summaries by provider and model
+func AggregateByProviderModel(summaries []Summary) []*ProviderModelStats {
 	statsMap := make(map[string]*ProviderModelStats)
 
-	for _, r := range records {
-		key := r.Provider + ":" + r.Model
+	for _, s := range summaries {
+		key := s.Provider + ":" + s.Model
 		if statsMap[key] == nil {
 			statsMap[key] = &ProviderModelStats{
-				Provider: r.Provider,
-				Model:    r.Model,
+				Provider: s.Provider,
+				Model:    s.Model,
 			}
 		}
 
-		statsMap[key].Count++
-		statsMap[key].InputTokens += r.InputTokens
-		statsMap[key].OutputTokens += r.OutputTokens
-		statsMap[key].CacheReadTokens += r.CacheReadTokens
+		statsMap[key].Count += s.Count
+		statsMap[key].InputTokens += s.InputTokens
+		statsMap[key].OutputTokens += s.OutputTokens
+		statsMap[key].CacheCreationTokens += s.CacheCreationTokens
+		statsMap[key].CacheReadTokens += s.CacheReadTokens
+		statsMap[key].Add(&s)
 	}
 
 	// Convert map to slice for easier sorting
@@ -188,8 +365,3 @@ func AggregateByProviderModel(records []Record) []*ProviderModelStats {
 
 	return stats
 }
-
-// generateID generates a unique ID based on timestamp
-func generateID() string {
-	return fmt.Sprintf("%d", time.Now().UnixNano())
-}